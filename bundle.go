@@ -0,0 +1,225 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package vai
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/noxsios/vai/uses"
+)
+
+// BundleManifestName is the name of the manifest at the root of a bundle.
+const BundleManifestName = "bundle.json"
+
+// BundleManifest describes the contents of a bundle.
+type BundleManifest struct {
+	// Origin is the origin of the root workflow
+	Origin string `json:"origin"`
+	// Refs maps every reference reachable from the root workflow to its content
+	Refs uses.RefIndex `json:"refs"`
+}
+
+type refIndexKey struct{}
+
+// WithRefIndex returns a copy of ctx in which all `uses` references are resolved
+// through refs and served from the store, without reaching out to the network.
+func WithRefIndex(ctx context.Context, refs uses.RefIndex) context.Context {
+	return context.WithValue(ctx, refIndexKey{}, refs)
+}
+
+func refIndexFromContext(ctx context.Context) (uses.RefIndex, bool) {
+	refs, ok := ctx.Value(refIndexKey{}).(uses.RefIndex)
+	return refs, ok
+}
+
+// CreateBundle packs the workflow at origin, and every workflow transitively referenced
+// by the given tasks, into a gzipped tarball.
+//
+// If no tasks are given, every task in the root workflow, and in the workflows it includes, is bundled.
+func CreateBundle(ctx context.Context, store *uses.Store, origin string, tasks []string, w io.Writer) error {
+	logger := log.FromContext(ctx)
+
+	root, err := url.Parse(origin)
+	if err != nil {
+		return err
+	}
+
+	ref := reference{
+		uri:      root,
+		previous: root,
+		location: origin,
+		origin:   origin,
+	}
+
+	wf, refs, err := load(ctx, store, ref)
+	if err != nil {
+		return err
+	}
+
+	manifest := BundleManifest{
+		Origin: origin,
+		Refs:   refs,
+	}
+
+	if len(tasks) == 0 {
+		tasks = wf.OrderedTaskNames()
+		for ns := range wf.Includes() {
+			tasks = append(tasks, IncludeKey(ns))
+		}
+	}
+
+	// signatures are bundled too, so they can be verified offline
+	deps, err := Prefetch(ctx, store, wf, origin, tasks)
+	if err != nil {
+		return err
+	}
+	maps.Copy(manifest.Refs, deps)
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	mb, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := tw.WriteHeader(&tar.Header{
+		Name: BundleManifestName,
+		Mode: 0644,
+		Size: int64(len(mb)),
+	}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(mb); err != nil {
+		return err
+	}
+
+	written := make(map[string]bool, len(manifest.Refs))
+	keys := make([]string, 0, len(manifest.Refs))
+	for k := range manifest.Refs {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	for _, k := range keys {
+		desc := manifest.Refs[k]
+		if written[desc.Hex] {
+			continue
+		}
+		written[desc.Hex] = true
+
+		logger.Debug("bundling", "ref", k, "digest", desc.Hex)
+
+		rc, err := store.Fetch(desc)
+		if err != nil {
+			return err
+		}
+
+		if err := tw.WriteHeader(&tar.Header{
+			Name: filepath.ToSlash(uses.BlobPath(desc.Hex)),
+			Mode: 0644,
+			Size: desc.Size,
+		}); err != nil {
+			rc.Close()
+			return err
+		}
+
+		_, err = io.Copy(tw, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// OpenBundle seeds the store with the contents of a bundle created by CreateBundle,
+// returning the bundle's manifest and root workflow.
+//
+// Run the root workflow with a context from WithRefIndex(ctx, manifest.Refs) to avoid any network access.
+func OpenBundle(store *uses.Store, r io.Reader) (Workflow, BundleManifest, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, BundleManifest{}, err
+	}
+	defer gr.Close()
+
+	tr := tar.NewReader(gr)
+
+	var manifest BundleManifest
+	var found bool
+
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, BundleManifest{}, err
+		}
+
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		if hdr.Name == BundleManifestName {
+			if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+				return nil, BundleManifest{}, err
+			}
+			found = true
+			continue
+		}
+
+		hex := strings.ReplaceAll(strings.TrimPrefix(hdr.Name, uses.BlobDir+"/"), "/", "")
+		if filepath.ToSlash(uses.BlobPath(hex)) != hdr.Name {
+			return nil, BundleManifest{}, fmt.Errorf("unexpected file in bundle: %s", hdr.Name)
+		}
+
+		if err := store.Store(tr); err != nil {
+			return nil, BundleManifest{}, err
+		}
+
+		if err := store.Verify(uses.Descriptor{Hex: hex, Size: hdr.Size}); err != nil {
+			return nil, BundleManifest{}, fmt.Errorf("bundle content %s is corrupt: %w", hdr.Name, err)
+		}
+	}
+
+	if !found {
+		return nil, BundleManifest{}, fmt.Errorf("%s not found in bundle", BundleManifestName)
+	}
+
+	desc, ok := manifest.Refs[manifest.Origin]
+	if !ok {
+		return nil, BundleManifest{}, fmt.Errorf("root workflow %s not found in bundle", manifest.Origin)
+	}
+
+	f, err := store.Fetch(desc)
+	if err != nil {
+		return nil, BundleManifest{}, err
+	}
+	defer f.Close()
+
+	wf, err := ReadAndValidate(f)
+	if err != nil {
+		return nil, BundleManifest{}, err
+	}
+
+	return wf, manifest, nil
+}
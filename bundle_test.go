@@ -0,0 +1,115 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package vai
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/goccy/go-yaml"
+	"github.com/noxsios/vai/uses"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBundle(t *testing.T) {
+	ctx := context.Background()
+
+	workflowFoo := Workflow{
+		"default": {Step{Uses: "file:bar/baz.yaml?task=baz"}},
+		"other":   {Step{Uses: "local"}},
+		"local":   {Step{Uses: "file:hello-world.yaml?task=a-task"}},
+	}
+	workflowBaz := Workflow{"baz": {Step{Run: "echo 'baz'"}, Step{Uses: "file:../hello-world.yaml"}}}
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		var wf Workflow
+		switch r.URL.Path {
+		case "/foo.yaml":
+			wf = workflowFoo
+		case "/bar/baz.yaml":
+			wf = workflowBaz
+		case "/hello-world.yaml":
+			wf = helloWorldWorkflow
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		b, err := yaml.Marshal(wf)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(b)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	origin := server.URL + "/foo.yaml"
+
+	store, err := uses.NewStore(afero.NewMemMapFs())
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, CreateBundle(ctx, store, origin, nil, &buf))
+
+	gr, err := gzip.NewReader(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	tr := tar.NewReader(gr)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, hdr.Name)
+	}
+	// manifest, foo.yaml, baz.yaml, and hello-world.yaml
+	require.Len(t, names, 4)
+	require.Equal(t, BundleManifestName, names[0])
+
+	// the network is no longer available
+	server.Close()
+
+	offline, err := uses.NewStore(afero.NewMemMapFs())
+	require.NoError(t, err)
+
+	wf, manifest, err := OpenBundle(offline, bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, workflowFoo, wf)
+	require.Equal(t, origin, manifest.Origin)
+	require.Len(t, manifest.Refs, 3)
+
+	ctx = WithRefIndex(ctx, manifest.Refs)
+
+	for _, task := range []string{"default", "other"} {
+		require.NoError(t, Run(ctx, offline, wf, task, With{}, manifest.Origin, false))
+	}
+
+	// references outside the bundle cannot be resolved
+	err = ExecuteUses(ctx, offline, "https://example.com/vai.yaml", With{}, manifest.Origin, false)
+	require.EqualError(t, err, "https://example.com/vai.yaml not found in ref index")
+
+	// bundling an unknown task fails
+	err = CreateBundle(context.Background(), store, "file:testdata/hello-world.yaml", []string{"dne"}, io.Discard)
+	require.EqualError(t, err, `task "dne" not found`)
+
+	// garbage is rejected
+	_, _, err = OpenBundle(offline, bytes.NewReader([]byte("not a bundle")))
+	require.EqualError(t, err, "gzip: invalid header")
+
+	var empty bytes.Buffer
+	gw := gzip.NewWriter(&empty)
+	require.NoError(t, tar.NewWriter(gw).Close())
+	require.NoError(t, gw.Close())
+	_, _, err = OpenBundle(offline, &empty)
+	require.EqualError(t, err, "bundle.json not found in bundle")
+}
@@ -5,14 +5,21 @@
 package cmd
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"maps"
+	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"runtime/debug"
+	"strings"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/charmbracelet/log"
@@ -25,13 +32,25 @@ import (
 // NewRootCmd creates the root command for the vai CLI.
 func NewRootCmd() *cobra.Command {
 	var (
-		w        map[string]string
-		level    string
-		ver      bool
-		list     bool
-		filename string
-		timeout  time.Duration
-		dry      bool
+		w          map[string]string
+		level      string
+		ver        bool
+		list       bool
+		filename   string
+		timeout    time.Duration
+		dry        bool
+		bundle     string
+		fromBundle string
+		publish    string
+		outdated   bool
+		update     bool
+		replace    []string
+		policy     string
+		trust      bool
+		maxDepth   int
+		prefetch   bool
+		limits     vai.FetchLimits
+		overrides  []string
 	)
 
 	root := &cobra.Command{
@@ -47,11 +66,22 @@ func NewRootCmd() *cobra.Command {
 			}
 			defer f.Close()
 
-			wf, err := vai.ReadAndValidate(f)
+			wf, err := vai.Read(f)
 			if err != nil {
 				return nil, cobra.ShellCompDirectiveNoFileComp
 			}
 
+			if local := vai.LocalOverrideFileName(filename); fileExists(local) {
+				wf, err = vai.ReadOverrides(wf, local)
+				if err != nil {
+					return nil, cobra.ShellCompDirectiveNoFileComp
+				}
+			}
+
+			if err := vai.Validate(wf); err != nil {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+
 			return wf.OrderedTaskNames(), cobra.ShellCompDirectiveNoFileComp
 		},
 		PreRunE: func(cmd *cobra.Command, _ []string) error {
@@ -93,23 +123,205 @@ func NewRootCmd() *cobra.Command {
 				}
 			}
 
-			if filename == "" {
-				filename = vai.DefaultFileName
+			var cacheDirectory string
+
+			if cache, ok := os.LookupEnv(vai.CacheEnvVar); ok {
+				cacheDirectory = cache
+			} else {
+				home, err := os.UserHomeDir()
+				if err != nil {
+					return err
+				}
+
+				cacheDirectory = filepath.Join(home, ".vai", "cache")
+
+				if err := os.MkdirAll(cacheDirectory, 0777); err != nil {
+					return err
+				}
 			}
 
-			f, err := os.Open(filename)
+			store, err := uses.NewStore(afero.NewBasePathFs(afero.NewOsFs(), cacheDirectory))
+			if err != nil {
+				return err
+			}
+
+			configPath, err := vai.ConfigPath()
 			if err != nil {
 				return err
 			}
-			defer f.Close()
 
-			wf, err := vai.ReadAndValidate(f)
+			cfg, err := vai.ReadConfig(configPath)
 			if err != nil {
 				return err
 			}
 
+			replacements, err := vai.ParseReplacements(replace)
+			if err != nil {
+				return err
+			}
+
+			// replacements from the command line take precedence over the config file
+			if cfg.Replace == nil {
+				cfg.Replace = make(vai.Replacements)
+			}
+			maps.Copy(cfg.Replace, replacements)
+
+			if len(cfg.Replace) > 0 {
+				ctx = vai.WithReplacements(ctx, cfg.Replace)
+			}
+
+			if len(cfg.Rewrite) > 0 {
+				ctx = vai.WithRewrites(ctx, cfg.Rewrite)
+			}
+
+			if len(cfg.Verify) > 0 {
+				ctx = vai.WithVerifyRules(ctx, cfg.Verify)
+			}
+
+			if len(cfg.HTTP) > 0 {
+				ctx = uses.WithHTTPRules(ctx, cfg.HTTP)
+			}
+
+			if policy == "" {
+				policy = os.Getenv(vai.PolicyEnvVar)
+			}
+
+			if policy != "" {
+				p, err := vai.ReadPolicy(policy)
+				if err != nil {
+					return err
+				}
+				ctx = vai.WithPolicy(ctx, p)
+			}
+
+			ctx = vai.WithConfirm(ctx, func(location, diff string) (bool, error) {
+				if diff == "" {
+					logger.Warnf("%s changed since it was last run, the previous version is no longer cached", location)
+				} else {
+					logger.Warnf("%s changed since it was last run:\n%s", location, strings.TrimSuffix(diff, "\n"))
+				}
+
+				if trust {
+					return true, nil
+				}
+
+				// only prompt when a person is at the keyboard
+				fi, err := os.Stdin.Stat()
+				if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+					return false, fmt.Errorf("%s changed since it was last run, review the change and re-run with --trust to accept it", location)
+				}
+
+				fmt.Fprint(os.Stderr, "Run the changed workflow? [y/N] ")
+				answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+				if err != nil && !errors.Is(err, io.EOF) {
+					return false, err
+				}
+				answer = strings.ToLower(strings.TrimSpace(answer))
+				return answer == "y" || answer == "yes", nil
+			})
+
+			ctx = vai.WithMaxDepth(ctx, maxDepth)
+			ctx = vai.WithFetchLimits(ctx, limits)
+			ctx = uses.WithArchiveCache(ctx)
+			ctx = uses.WithCredentialCache(ctx)
+
+			// opening and listing a remote workflow fetches it, which counts towards the timeout too
+			if timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+
+			var wf vai.Workflow
+			var rootOrigin string
+
+			if fromBundle != "" {
+				f, err := os.Open(fromBundle)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+
+				var manifest vai.BundleManifest
+				wf, manifest, err = vai.OpenBundle(store, f)
+				if err != nil {
+					return err
+				}
+
+				ctx = vai.WithRefIndex(ctx, manifest.Refs)
+				rootOrigin = manifest.Origin
+			} else {
+				if filename == "" {
+					filename = vai.DefaultFileName
+				}
+
+				switch {
+				case filename == "-":
+					b, err := readAll(ctx, os.Stdin)
+					if err != nil {
+						if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+							return fmt.Errorf("reading stdin timed out")
+						}
+						return err
+					}
+
+					wf, err = vai.Read(bytes.NewReader(b))
+					if err != nil {
+						return err
+					}
+
+					// relative references are resolved against the current directory
+					rootOrigin = "file:-"
+				case isReference(filename):
+					// nothing is run when listing, so there is nothing to review
+					wf, rootOrigin, err = vai.Open(ctx, store, filename, dry || list)
+					if err != nil {
+						if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+							return fmt.Errorf("opening %s timed out", filename)
+						}
+						return err
+					}
+				default:
+					f, err := os.Open(filename)
+					if err != nil {
+						return err
+					}
+					defer f.Close()
+
+					wf, err = vai.Read(f)
+					if err != nil {
+						return err
+					}
+
+					rootOrigin = "file:" + filename
+
+					// personal tweaks to a local workflow are applied before any given with --override,
+					// except by commands that work on the workflow file as committed
+					local := vai.LocalOverrideFileName(filename)
+					committed := bundle != "" || publish != "" || outdated || update
+					if fileExists(local) && !committed {
+						overrides = append([]string{local}, overrides...)
+					}
+				}
+
+				wf, err = vai.ReadOverrides(wf, overrides...)
+				if err != nil {
+					return err
+				}
+
+				if err := vai.Validate(wf); err != nil {
+					return err
+				}
+			}
+
 			if list {
-				names := wf.OrderedTaskNames()
+				names, err := vai.ListTasks(ctx, store, wf, rootOrigin)
+				if err != nil {
+					if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+						return fmt.Errorf("listing timed out")
+					}
+					return err
+				}
 
 				if len(names) == 0 {
 					return fmt.Errorf("no tasks available")
@@ -123,43 +335,155 @@ func NewRootCmd() *cobra.Command {
 				return nil
 			}
 
-			with := make(vai.With)
-			for k, v := range w {
-				with[k] = v
+			if err := vai.ValidatePolicy(ctx, wf, rootOrigin); err != nil {
+				return err
 			}
 
-			if len(args) == 0 {
-				args = append(args, vai.DefaultTaskName)
+			if filename == "-" || isReference(filename) {
+				switch {
+				case bundle != "":
+					return fmt.Errorf("--bundle requires a local workflow file")
+				case publish != "":
+					return fmt.Errorf("--publish requires a local workflow file")
+				case outdated || update:
+					return fmt.Errorf("--outdated and --update require a local workflow file")
+				}
 			}
 
-			if timeout > 0 {
-				var cancel context.CancelFunc
-				ctx, cancel = context.WithTimeout(ctx, timeout)
-				defer cancel()
+			// these work on the workflow file as committed, explicit overrides would be silently left out
+			if len(overrides) > 0 {
+				var flag string
+				switch {
+				case bundle != "":
+					flag = "--bundle"
+				case publish != "":
+					flag = "--publish"
+				case outdated:
+					flag = "--outdated"
+				case update:
+					flag = "--update"
+				}
+				if flag != "" {
+					return fmt.Errorf("%s cannot be used with --override: %s", flag, strings.Join(overrides, ", "))
+				}
 			}
 
-			var cacheDirectory string
+			if bundle != "" {
+				f, err := os.Create(bundle)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
 
-			if cache, ok := os.LookupEnv(vai.CacheEnvVar); ok {
-				cacheDirectory = cache
-			} else {
-				home, err := os.UserHomeDir()
+				if err := vai.CreateBundle(ctx, store, rootOrigin, args, f); err != nil {
+					return err
+				}
+
+				return f.Close()
+			}
+
+			if publish != "" {
+				digest, err := vai.Publish(ctx, filename, publish)
 				if err != nil {
 					return err
 				}
 
-				cacheDirectory = filepath.Join(home, ".vai", "cache")
+				logger.Printf("Published %s to %s", digest, publish)
+				return nil
+			}
 
-				if err := os.MkdirAll(cacheDirectory, 0777); err != nil {
+			if outdated || update {
+				b, err := os.ReadFile(filename)
+				if err != nil {
+					return err
+				}
+
+				if update {
+					// version ranges are resolved against the latest tags again, and recorded for later runs
+					if _, err := vai.UpdateRanges(ctx, store, b, rootOrigin); err != nil {
+						return err
+					}
+				}
+
+				pins, err := vai.CheckPins(ctx, b, rootOrigin)
+				if err != nil {
+					return err
+				}
+
+				var buf bytes.Buffer
+				tw := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+				fmt.Fprintln(tw, "USES\tCURRENT\tLATEST")
+				n := 0
+				for _, pin := range pins {
+					if pin.Outdated() {
+						fmt.Fprintf(tw, "%s\t%s\t%s\n", pin.Uses, pin.Current, pin.Latest)
+						n++
+					}
+				}
+				if err := tw.Flush(); err != nil {
 					return err
 				}
+
+				if n == 0 {
+					logger.Print("All pinned workflows are up to date")
+					return nil
+				}
+
+				logger.Print(strings.TrimSuffix(buf.String(), "\n"))
+
+				if !update {
+					return nil
+				}
+
+				b, err = vai.UpdatePins(b, pins)
+				if err != nil {
+					return err
+				}
+
+				fi, err := os.Stat(filename)
+				if err != nil {
+					return err
+				}
+
+				if err := os.WriteFile(filename, b, fi.Mode()); err != nil {
+					return err
+				}
+
+				logger.Printf("Updated %d pinned workflow(s) in %s", n, filename)
+				return nil
 			}
 
-			store, err := uses.NewStore(afero.NewBasePathFs(afero.NewOsFs(), cacheDirectory))
+			with := make(vai.With)
+			for k, v := range w {
+				with[k] = v
+			}
+
+			if len(args) == 0 {
+				args = append(args, vai.DefaultTaskName)
+			}
+
+			if prefetch {
+				refs, err := vai.Prefetch(ctx, store, wf, rootOrigin, args)
+				if err != nil {
+					if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+						return fmt.Errorf("prefetch timed out")
+					}
+					return err
+				}
+
+				logger.Printf("Prefetched %d reference(s)", len(refs))
+				return nil
+			}
+
+			// resolve and fetch every remote workflow up front, so tasks are run from the cache
+			planned, err := vai.Plan(ctx, store, wf, rootOrigin, args)
 			if err != nil {
+				if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+					return fmt.Errorf("planning timed out")
+				}
 				return err
 			}
-			rootOrigin := "file:" + filename
+			ctx = planned
 
 			for _, call := range args {
 				if err := vai.Run(ctx, store, wf, call, with, rootOrigin, dry); err != nil {
@@ -177,13 +501,43 @@ func NewRootCmd() *cobra.Command {
 	root.Flags().StringVarP(&level, "log-level", "l", "info", "Set log level")
 	root.Flags().BoolVarP(&ver, "version", "V", false, "Print version number and exit")
 	root.Flags().BoolVar(&list, "list", false, "Print list of available tasks and exit")
-	root.Flags().StringVarP(&filename, "file", "f", "", "Read file as workflow definition")
+	root.Flags().StringVarP(&filename, "file", "f", "", "Read file, remote reference (e.g. pkg:github/org/repo@v1#vai.yaml) or - for stdin as workflow definition")
 	root.Flags().DurationVarP(&timeout, "timeout", "t", time.Hour, "Maximum time allowed for execution")
 	root.Flags().BoolVar(&dry, "dry-run", false, "Don't actually run anything; just print")
+	root.Flags().IntVar(&limits.Total, "concurrency", vai.DefaultConcurrency, "Maximum number of remote workflows fetched at the same time")
+	root.Flags().IntVar(&limits.PerHost, "host-concurrency", vai.DefaultHostConcurrency, "Maximum number of remote workflows fetched at the same time from a single host")
+	root.Flags().BoolVar(&prefetch, "prefetch", false, "Fetch the remote workflows and scripts used by the called task(s) into the cache without running anything and exit")
+	root.Flags().IntVar(&maxDepth, "max-depth", vai.DefaultMaxDepth, "Maximum depth of nested task calls")
+	root.Flags().StringVar(&bundle, "bundle", "", "Pack the workflow and its remote dependencies into an archive and exit")
+	root.Flags().StringVar(&fromBundle, "from-bundle", "", "Run from an archive created with --bundle, without network access")
+	root.MarkFlagsMutuallyExclusive("file", "from-bundle")
+	root.Flags().StringArrayVar(&overrides, "override", nil, "Merge an override file on top of the workflow, may be repeated (vai.local.yaml is merged first when present)")
+	root.MarkFlagsMutuallyExclusive("override", "from-bundle")
+	root.Flags().StringVar(&publish, "publish", "", "Push the workflow and its relative file: dependencies to an OCI registry (oci://<registry>/<repository>:<tag>) and exit")
+	root.MarkFlagsMutuallyExclusive("bundle", "from-bundle")
+	root.MarkFlagsMutuallyExclusive("publish", "from-bundle")
+	root.MarkFlagsMutuallyExclusive("publish", "bundle")
+	root.Flags().StringArrayVar(&replace, "replace", nil, "Redirect remote uses to another location for this run, e.g. --replace pkg:github/acme/tasks=file:../tasks")
+	root.Flags().StringVar(&policy, "policy", "", "Restrict remote uses to those allowed by a policy file (default $VAI_POLICY)")
+	root.Flags().BoolVar(&trust, "trust", false, "Run remote workflows that changed since they were last run without asking")
+	root.Flags().BoolVar(&outdated, "outdated", false, "Print pinned pkg: workflows that have newer tags available and exit")
+	root.Flags().BoolVar(&update, "update", false, "Update pinned pkg: workflows to their latest tags in place and exit")
+	root.MarkFlagsMutuallyExclusive("outdated", "update")
+	root.MarkFlagsMutuallyExclusive("outdated", "from-bundle")
+	root.MarkFlagsMutuallyExclusive("update", "from-bundle")
+	root.MarkFlagsMutuallyExclusive("prefetch", "from-bundle")
+	root.MarkFlagsMutuallyExclusive("prefetch", "dry-run")
 
 	return root
 }
 
+// isReference reports whether the --file value is a `uses` reference rather than a path.
+func isReference(filename string) bool {
+	u, err := url.Parse(filename)
+	// single letter schemes are Windows drive letters
+	return err == nil && len(u.Scheme) > 1 && uses.SupportsScheme(u.Scheme)
+}
+
 // Main executes the root command for the vai CLI.
 //
 // It returns 0 on success, 1 on failure and logs any errors.
@@ -207,3 +561,31 @@ func Main() int {
 	}
 	return 0
 }
+
+// readAll reads r until EOF, or until ctx is done.
+func readAll(ctx context.Context, r io.Reader) ([]byte, error) {
+	type result struct {
+		b   []byte
+		err error
+	}
+
+	// reads from stdin cannot be interrupted, so the read is left behind when ctx is done
+	done := make(chan result, 1)
+	go func() {
+		b, err := io.ReadAll(r)
+		done <- result{b, err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.b, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// fileExists reports whether a file exists at path.
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
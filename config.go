@@ -0,0 +1,111 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package vai
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/noxsios/vai/uses"
+)
+
+// ConfigEnvVar is the environment variable for the path to the config file.
+const ConfigEnvVar = "VAI_CONFIG"
+
+// Config is user level configuration, read from ~/.vai/config.yaml
+type Config struct {
+	// Replace redirects remote `uses` references, see Replacements
+	Replace Replacements `json:"replace,omitempty"`
+	// Rewrite redirects remote `uses` references by host or repository, see RewriteRule
+	Rewrite Rewrites `json:"rewrite,omitempty"`
+	// Verify requires remote workflows to be signed, see VerifyRule
+	Verify VerifyRules `json:"verify,omitempty"`
+	// HTTP configures auth, TLS, timeouts and retries of HTTP requests per host, see uses.HTTPRule
+	HTTP uses.HTTPRules `json:"http,omitempty"`
+}
+
+// ConfigPath returns the location of the config file, VAI_CONFIG or ~/.vai/config.yaml
+func ConfigPath() (string, error) {
+	if p, ok := os.LookupEnv(ConfigEnvVar); ok {
+		return p, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, ".vai", "config.yaml"), nil
+}
+
+// ReadConfig reads the config file at path.
+//
+// A missing config file is not an error, and results in an empty config.
+// Relative `file:` replacements and certificate paths are made relative to the directory of the config file.
+func ReadConfig(path string) (Config, error) {
+	var cfg Config
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+
+	if err := yaml.UnmarshalWithOptions(b, &cfg, yaml.DisallowUnknownField()); err != nil {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for i, rule := range cfg.Rewrite {
+		if rule.From == "" || rule.To == "" {
+			return cfg, fmt.Errorf("%s: rewrite[%d] must set both from and to", path, i)
+		}
+		if strings.HasPrefix(rule.From, "pkg:") != strings.HasPrefix(rule.To, "pkg:") {
+			return cfg, fmt.Errorf("%s: rewrite[%d] can only rewrite pkg: references to other pkg: references", path, i)
+		}
+	}
+
+	if err := cfg.Verify.Validate(); err != nil {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if err := cfg.HTTP.Validate(); err != nil {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return cfg, err
+	}
+
+	// certificate and key files are relative to the config file, like file: replacements
+	for i := range cfg.HTTP {
+		for _, p := range []*string{&cfg.HTTP[i].CACert, &cfg.HTTP[i].ClientCert, &cfg.HTTP[i].ClientKey} {
+			if *p != "" && !filepath.IsAbs(*p) {
+				*p = filepath.Join(dir, *p)
+			}
+		}
+	}
+
+	for from, to := range cfg.Replace {
+		if !strings.HasPrefix(to, "file:") {
+			continue
+		}
+		u, err := url.Parse(to)
+		if err != nil {
+			return cfg, fmt.Errorf("%s: replace %q: %w", path, from, err)
+		}
+		if u.Opaque != "" {
+			cfg.Replace[from] = "file:" + filepath.ToSlash(filepath.Join(dir, u.Opaque))
+		}
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,119 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package vai
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/noxsios/vai/uses"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadConfig(t *testing.T) {
+	dir := t.TempDir()
+
+	cfg, err := ReadConfig(filepath.Join(dir, "dne.yaml"))
+	require.NoError(t, err)
+	require.Equal(t, Config{}, cfg)
+
+	p := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(p, []byte(`replace:
+  pkg:github/acme/tasks: file:../tasks
+  pkg:github/acme/abs: file:/opt/abs
+  pkg:github/acme/lib: pkg:gitlab/fork/lib
+`), 0644))
+
+	cfg, err = ReadConfig(p)
+	require.NoError(t, err)
+	require.Equal(t, Config{
+		Replace: Replacements{
+			"pkg:github/acme/tasks": "file:" + filepath.ToSlash(filepath.Join(filepath.Dir(dir), "tasks")),
+			"pkg:github/acme/abs":   "file:/opt/abs",
+			"pkg:github/acme/lib":   "pkg:gitlab/fork/lib",
+		},
+	}, cfg)
+
+	require.NoError(t, os.WriteFile(p, []byte(`rewrite:
+  - from: pkg:github/*
+    to: pkg:gitlab/mirror/*?base=https://git.internal
+  - from: https://raw.githubusercontent.com/
+    to: https://proxy.internal/raw/
+`), 0644))
+
+	cfg, err = ReadConfig(p)
+	require.NoError(t, err)
+	require.Equal(t, Config{
+		Rewrite: Rewrites{
+			{From: "pkg:github/*", To: "pkg:gitlab/mirror/*?base=https://git.internal"},
+			{From: "https://raw.githubusercontent.com/", To: "https://proxy.internal/raw/"},
+		},
+	}, cfg)
+
+	require.NoError(t, os.WriteFile(p, []byte("rewrite: [{from: pkg:github/*}]\n"), 0644))
+	_, err = ReadConfig(p)
+	require.EqualError(t, err, p+": rewrite[0] must set both from and to")
+
+	require.NoError(t, os.WriteFile(p, []byte("rewrite: [{from: pkg:github/*, to: https://example.com/}]\n"), 0644))
+	_, err = ReadConfig(p)
+	require.EqualError(t, err, p+": rewrite[0] can only rewrite pkg: references to other pkg: references")
+
+	key := "RWQf6LRCGA9i53mlYecO4IzT51TGPpvWucNSCh1CBM0QTaLn73Y7GFO3"
+	require.NoError(t, os.WriteFile(p, []byte("verify: [{match: [github/platform/*], keys: ["+key+"]}]\n"), 0644))
+	cfg, err = ReadConfig(p)
+	require.NoError(t, err)
+	require.Equal(t, Config{
+		Verify: VerifyRules{{Match: []string{"github/platform/*"}, Keys: []string{key}}},
+	}, cfg)
+
+	require.NoError(t, os.WriteFile(p, []byte("verify: [{match: [github/platform/*]}]\n"), 0644))
+	_, err = ReadConfig(p)
+	require.EqualError(t, err, p+": verify[0] must set both match and keys")
+
+	require.NoError(t, os.WriteFile(p, []byte("verify: [{match: [github/platform/*], keys: [RWQ]}]\n"), 0644))
+	_, err = ReadConfig(p)
+	require.EqualError(t, err, p+`: verify[0] invalid minisign public key "RWQ"`)
+
+	require.NoError(t, os.WriteFile(p, []byte(`http:
+  - match: [workflows.internal]
+    token: $WORKFLOWS_TOKEN
+    headers:
+      X-Team: platform
+    ca-cert: certs/ca.pem
+    client-cert: /etc/vai/client.pem
+    client-key: /etc/vai/client-key.pem
+    timeout: 30s
+    retries: 5
+`), 0644))
+	cfg, err = ReadConfig(p)
+	require.NoError(t, err)
+	retries := 5
+	require.Equal(t, Config{
+		HTTP: uses.HTTPRules{{
+			Match:      []string{"workflows.internal"},
+			Token:      "$WORKFLOWS_TOKEN",
+			Headers:    map[string]string{"X-Team": "platform"},
+			CACert:     filepath.Join(dir, "certs", "ca.pem"),
+			ClientCert: "/etc/vai/client.pem",
+			ClientKey:  "/etc/vai/client-key.pem",
+			Timeout:    30 * time.Second,
+			Retries:    &retries,
+		}},
+	}, cfg)
+
+	require.NoError(t, os.WriteFile(p, []byte("http: [{match: [\"*\"], client-cert: client.pem}]\n"), 0644))
+	_, err = ReadConfig(p)
+	require.EqualError(t, err, p+": http[0] must set both client-cert and client-key")
+
+	require.NoError(t, os.WriteFile(p, []byte("unknown: true\n"), 0644))
+	_, err = ReadConfig(p)
+	require.ErrorContains(t, err, `unknown field "unknown"`)
+
+	t.Setenv(ConfigEnvVar, p)
+	cp, err := ConfigPath()
+	require.NoError(t, err)
+	require.Equal(t, p, cp)
+}
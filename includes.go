@@ -0,0 +1,174 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package vai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/noxsios/vai/uses"
+)
+
+// IncludePattern is a regular expression for the keys of included workflows, `<namespace>:*`
+//
+// Namespaces are valid URL schemes, so `uses: <namespace>:<task>` parses like any other reference.
+var IncludePattern = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9-]*):\*$`)
+
+// IncludeKey returns the key of the workflow included under namespace.
+func IncludeKey(namespace string) string {
+	return namespace + ":*"
+}
+
+// Includes returns the `uses` reference of every workflow included in wf, keyed by namespace.
+//
+// An include is stored as a task keyed `<namespace>:*` with a single `uses` step.
+func (wf Workflow) Includes() map[string]string {
+	includes := make(map[string]string)
+	for name, task := range wf {
+		m := IncludePattern.FindStringSubmatch(name)
+		if m == nil || len(task) == 0 {
+			continue
+		}
+		includes[m[1]] = task[0].Uses
+	}
+	return includes
+}
+
+// include returns the reference of the workflow included under the namespace of call, and the task within it.
+func (wf Workflow) include(call string) (string, string, bool) {
+	namespace, task, ok := strings.Cut(call, ":")
+	if !ok {
+		return "", "", false
+	}
+
+	t, ok := wf[IncludeKey(namespace)]
+	if !ok || len(t) == 0 {
+		return "", "", false
+	}
+
+	return t[0].Uses, task, true
+}
+
+// local reports whether call is a task in wf, or in a workflow it includes.
+func (wf Workflow) local(call string) bool {
+	if _, ok := wf.Find(call); ok {
+		return true
+	}
+	_, _, ok := wf.include(call)
+	return ok
+}
+
+// withTask returns the reference u calling task, replacing any task it already calls.
+func withTask(u, task string) (string, error) {
+	uri, err := url.Parse(u)
+	if err != nil {
+		return "", err
+	}
+
+	q := uri.Query()
+	q.Set("task", task)
+	uri.RawQuery = q.Encode()
+	return uri.String(), nil
+}
+
+// entry is the value of a top-level key in a workflow file, a list of steps or the reference of an included workflow.
+type entry struct {
+	task    Task
+	include string
+}
+
+// UnmarshalYAML decodes a reference or a list of steps
+func (e *entry) UnmarshalYAML(unmarshal func(any) error) error {
+	if err := unmarshal(&e.include); err == nil {
+		return nil
+	}
+	return unmarshal(&e.task)
+}
+
+// UnmarshalYAML decodes a workflow file, where `<namespace>:*` keys are set to the reference of an included workflow
+func (wf *Workflow) UnmarshalYAML(unmarshal func(any) error) error {
+	var entries map[string]entry
+	if err := unmarshal(&entries); err != nil {
+		return err
+	}
+
+	*wf = make(Workflow, len(entries))
+	for name, e := range entries {
+		isInclude := IncludePattern.MatchString(name)
+
+		switch {
+		case isInclude && e.include == "":
+			return fmt.Errorf("include %q must be set to a reference", name)
+		case isInclude:
+			(*wf)[name] = Task{{Uses: e.include}}
+		case e.include != "":
+			return fmt.Errorf("task %q must be a list of steps", name)
+		default:
+			(*wf)[name] = e.task
+		}
+	}
+	return nil
+}
+
+// MarshalJSON encodes a workflow as written in a workflow file, with includes as plain references
+func (wf Workflow) MarshalJSON() ([]byte, error) {
+	m := make(map[string]any, len(wf))
+	for name, task := range wf {
+		if IncludePattern.MatchString(name) && len(task) == 1 {
+			m[name] = task[0].Uses
+			continue
+		}
+		m[name] = task
+	}
+	return json.Marshal(m)
+}
+
+// ListTasks returns the names of the tasks in wf, followed by the tasks of every included workflow
+// prefixed with their namespace, eg. `go:build`.
+//
+// Included workflows are fetched relative to origin.
+func ListTasks(ctx context.Context, store *uses.Store, wf Workflow, origin string) ([]string, error) {
+	names := wf.OrderedTaskNames()
+
+	includes := wf.Includes()
+	namespaces := make([]string, 0, len(includes))
+	for ns := range includes {
+		namespaces = append(namespaces, ns)
+	}
+	slices.Sort(namespaces)
+
+	for _, ns := range namespaces {
+		ref, err := locate(ctx, store, includes[ns], origin)
+		if err != nil {
+			return nil, err
+		}
+
+		// workflows that include each other would otherwise be listed forever
+		ctx, err := enter(ctx, ref.origin, IncludeKey(ns))
+		if err != nil {
+			return nil, err
+		}
+
+		next, _, err := load(ctx, store, ref)
+		if err != nil {
+			return nil, err
+		}
+
+		nested, err := ListTasks(ctx, store, next, ref.origin)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, name := range nested {
+			names = append(names, ns+":"+name)
+		}
+	}
+
+	return names, nil
+}
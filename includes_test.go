@@ -0,0 +1,149 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package vai
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/noxsios/vai/uses"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadIncludes(t *testing.T) {
+	wf, err := Read(strings.NewReader(`
+go:*: file:lib/go.yaml
+default:
+  - uses: go:build
+`))
+	require.NoError(t, err)
+	require.Equal(t, Workflow{
+		"go:*":    {{Uses: "file:lib/go.yaml"}},
+		"default": {{Uses: "go:build"}},
+	}, wf)
+
+	require.Equal(t, map[string]string{"go": "file:lib/go.yaml"}, wf.Includes())
+	require.Equal(t, []string{"default"}, wf.OrderedTaskNames())
+
+	_, ok := wf.Find("go:*")
+	require.False(t, ok)
+
+	ref, task, ok := wf.include("go:build")
+	require.True(t, ok)
+	require.Equal(t, "file:lib/go.yaml", ref)
+	require.Equal(t, "build", task)
+
+	_, _, ok = wf.include("rust:build")
+	require.False(t, ok)
+	require.True(t, wf.local("go:test"))
+	require.False(t, wf.local("file:go.yaml"))
+
+	// includes are written back as plain references
+	b, err := json.Marshal(wf)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"go:*": "file:lib/go.yaml", "default": [{"uses": "go:build"}]}`, string(b))
+
+	_, err = Read(strings.NewReader("go:*:\n  - uses: file:lib/go.yaml\n"))
+	require.EqualError(t, err, `include "go:*" must be set to a reference`)
+
+	_, err = Read(strings.NewReader("default: file:lib/go.yaml\n"))
+	require.EqualError(t, err, `task "default" must be a list of steps`)
+}
+
+func TestValidateIncludes(t *testing.T) {
+	testCases := []struct {
+		name        string
+		wf          Workflow
+		expectedErr string
+	}{
+		{
+			"local include",
+			Workflow{"go:*": {{Uses: "file:lib/go.yaml"}}, "default": {{Uses: "go:build"}}},
+			"",
+		},
+		{
+			"missing scheme",
+			Workflow{"go:*": {{Uses: "lib/go.yaml"}}},
+			`include "go:*" must contain a scheme: "lib/go.yaml"`,
+		},
+		{
+			"namespace is a scheme",
+			Workflow{"file:*": {{Uses: "file:lib/go.yaml"}}},
+			`include "file:*" conflicts with the "file" scheme`,
+		},
+		{
+			"not a single uses step",
+			Workflow{"go:*": {{Uses: "file:lib/go.yaml"}, {Run: "echo"}}},
+			`include "go:*" must be set to a reference`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Validate(tc.wf)
+			if tc.expectedErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tc.expectedErr)
+			}
+		})
+	}
+}
+
+func TestIncludes(t *testing.T) {
+	files := map[string]string{
+		"/go.yaml":     "docker:*: file:docker.yaml\nbuild:\n  - run: echo \"building $TARGET\"\n    with:\n      target: input\ntest:\n  - uses: build\n    with:\n      target: input\n",
+		"/docker.yaml": "push:\n  - run: echo 'pushing'\n",
+	}
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		b, ok := files[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(b))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	ctx := context.Background()
+	store, err := uses.NewStore(afero.NewMemMapFs())
+	require.NoError(t, err)
+
+	wf := Workflow{
+		"go:*":    {{Uses: server.URL + "/go.yaml"}},
+		"default": {{Uses: "go:build", With: With{"target": `"release"`}}},
+	}
+	require.NoError(t, Validate(wf))
+
+	names, err := ListTasks(ctx, store, wf, "file:vai.yaml")
+	require.NoError(t, err)
+	require.Equal(t, []string{"default", "go:build", "go:test", "go:docker:push"}, names)
+
+	require.NoError(t, Run(ctx, store, wf, "", With{}, "file:vai.yaml", false))
+	require.NoError(t, Run(ctx, store, wf, "go:test", With{"target": "debug"}, "file:vai.yaml", false))
+	require.NoError(t, Run(ctx, store, wf, "go:docker:push", With{}, "file:vai.yaml", false))
+
+	err = Run(ctx, store, wf, "rust:build", With{}, "file:vai.yaml", false)
+	require.EqualError(t, err, `task "rust:build" not found`)
+
+	// every task of an included workflow is prefetched
+	refs, err := Prefetch(ctx, store, wf, "file:vai.yaml", []string{"go:*"})
+	require.NoError(t, err)
+	require.Contains(t, refs, server.URL+"/go.yaml")
+	require.Contains(t, refs, server.URL+"/docker.yaml")
+
+	// workflows that include each other are not listed forever
+	files["/docker.yaml"] = "go:*: file:go.yaml\npush:\n  - run: echo 'pushing'\n"
+	store, err = uses.NewStore(afero.NewMemMapFs())
+	require.NoError(t, err)
+	_, err = ListTasks(ctx, store, wf, "file:vai.yaml")
+	require.ErrorContains(t, err, "cycle")
+}
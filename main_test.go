@@ -4,9 +4,15 @@
 package vai_test
 
 import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 
+	"github.com/google/go-containerregistry/pkg/registry"
 	"github.com/noxsios/vai"
 	"github.com/noxsios/vai/cmd"
 	"github.com/rogpeppe/go-internal/testscript"
@@ -28,6 +34,18 @@ func TestCLI(t *testing.T) {
 		Setup: func(env *testscript.Env) error {
 			env.Setenv(vai.CacheEnvVar, t.TempDir())
 			env.Setenv("NO_COLOR", "true")
+
+			// an in-memory OCI registry to --publish to
+			server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+			env.Defer(server.Close)
+			env.Setenv("REGISTRY", strings.TrimPrefix(server.URL, "http://"))
+
+			// a server that never responds, to time out against
+			slow := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				<-r.Context().Done()
+			}))
+			env.Defer(slow.Close)
+			env.Setenv("SLOW", slow.URL)
 			return nil
 		},
 	})
@@ -0,0 +1,254 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package vai
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/charmbracelet/log"
+	"github.com/goccy/go-yaml/ast"
+	"github.com/goccy/go-yaml/parser"
+	"github.com/noxsios/vai/uses"
+	"github.com/package-url/packageurl-go"
+)
+
+// Pin is a `pkg:` reference in a workflow file whose version is a full semver tag, eg. v1.4.2.
+type Pin struct {
+	// Uses is the reference as written
+	Uses string
+	// Line and Column locate the reference in the workflow file
+	Line   int
+	Column int
+	// Current is the pinned tag
+	Current string
+	// Latest is the highest semver tag of the repository, excluding pre-releases
+	Latest string
+}
+
+// Outdated reports whether a newer tag than the pinned one is available.
+//
+// Floating tags like v1 are not pins, and are never outdated.
+func (p Pin) Outdated() bool {
+	if !uses.IsFullVersion(p.Current) || !uses.IsFullVersion(p.Latest) {
+		return false
+	}
+	current, err := semver.NewVersion(p.Current)
+	if err != nil {
+		return false
+	}
+	latest, err := semver.NewVersion(p.Latest)
+	if err != nil {
+		return false
+	}
+	return latest.GreaterThan(current)
+}
+
+// usesVisitor collects every `uses` string, and the reference of every include, in a YAML document.
+type usesVisitor struct {
+	nodes []*ast.StringNode
+}
+
+func (v *usesVisitor) Visit(node ast.Node) ast.Visitor {
+	if mv, ok := node.(*ast.MappingValueNode); ok && (mv.Key.String() == "uses" || IncludePattern.MatchString(mv.Key.String())) {
+		if s, ok := mv.Value.(*ast.StringNode); ok {
+			v.nodes = append(v.nodes, s)
+		}
+	}
+	return v
+}
+
+// usesNodes returns every `uses` string, and the reference of every include, in the workflow file b.
+func usesNodes(b []byte) ([]*ast.StringNode, error) {
+	file, err := parser.ParseBytes(b, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &usesVisitor{}
+	for _, doc := range file.Docs {
+		if doc.Body != nil {
+			ast.Walk(v, doc.Body)
+		}
+	}
+	return v.nodes, nil
+}
+
+// UpdateRanges resolves every `pkg:` reference in the workflow file b whose version is a semver range
+// against the latest tags again, recording the new resolutions in the store for later runs.
+//
+// origin is the origin of the workflow file. It returns the number of ranges resolved.
+func UpdateRanges(ctx context.Context, store *uses.Store, b []byte, origin string) (int, error) {
+	nodes, err := usesNodes(b)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx = WithUpdateVersions(ctx)
+
+	n := 0
+	for _, node := range nodes {
+		if !strings.HasPrefix(node.Value, "pkg:") {
+			continue
+		}
+
+		pURL, err := packageurl.FromString(node.Value)
+		if err != nil {
+			return 0, err
+		}
+
+		if !uses.IsVersionRange(pURL.Version) {
+			continue
+		}
+
+		if _, err := locate(ctx, store, node.Value, origin); err != nil {
+			return 0, err
+		}
+		n++
+	}
+
+	return n, nil
+}
+
+// CheckPins finds every `pkg:` reference in the workflow file b that is pinned to a semver tag,
+// and looks up the latest tag of each repository.
+//
+// origin is the origin of the workflow file, used to select fetchers.
+// Branches, commits and version ranges are not pins and are skipped.
+func CheckPins(ctx context.Context, b []byte, origin string) ([]Pin, error) {
+	logger := log.FromContext(ctx)
+
+	nodes, err := usesNodes(b)
+	if err != nil {
+		return nil, err
+	}
+
+	previous, err := url.Parse(origin)
+	if err != nil {
+		return nil, err
+	}
+
+	// repositories are often referenced more than once, only list their tags once
+	latest := make(map[string]string)
+
+	var pins []Pin
+	for _, node := range nodes {
+		if !strings.HasPrefix(node.Value, "pkg:") {
+			continue
+		}
+
+		pURL, err := packageurl.FromString(node.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		// floating tags like v1 are moved to each release on purpose
+		if !uses.IsFullVersion(pURL.Version) {
+			continue
+		}
+
+		// the host is part of the base or vcs_url qualifiers, other qualifiers (eg. task) do not change the repository
+		qualifiers := pURL.Qualifiers.Map()
+		repo := strings.Join([]string{pURL.Type, pURL.Namespace, pURL.Name, qualifiers["base"], qualifiers["vcs_url"]}, "|")
+		if _, ok := latest[repo]; !ok {
+			// list tags from the mirror, if there is one
+			location, _, err := rewritesFromContext(ctx).Apply(node.Value)
+			if err != nil {
+				return nil, err
+			}
+
+			uri, err := url.Parse(location)
+			if err != nil {
+				return nil, err
+			}
+
+			fetcher, err := uses.SelectFetcher(uri, previous)
+			if err != nil {
+				return nil, err
+			}
+
+			lister, ok := fetcher.(uses.TagLister)
+			if !ok {
+				return nil, fmt.Errorf("%T does not support listing tags: %s", fetcher, location)
+			}
+
+			logger.Debug("listing tags", "uses", location)
+
+			tags, err := lister.Tags(ctx, location)
+			if err != nil {
+				return nil, err
+			}
+
+			tags = slices.DeleteFunc(tags, func(tag string) bool {
+				return !uses.IsFullVersion(tag)
+			})
+
+			tag, err := uses.HighestMatch("*", tags)
+			if err != nil {
+				// no semver tags at all, nothing to compare against
+				tag = ""
+			}
+			latest[repo] = tag
+		}
+
+		tk := node.GetToken()
+		pins = append(pins, Pin{
+			Uses:    node.Value,
+			Line:    tk.Position.Line,
+			Column:  tk.Position.Column,
+			Current: pURL.Version,
+			Latest:  latest[repo],
+		})
+	}
+
+	return pins, nil
+}
+
+// UpdatePins returns a copy of the workflow file b with every outdated pin moved to its latest tag.
+//
+// Only the version within each reference is changed, formatting and comments are left untouched.
+func UpdatePins(b []byte, pins []Pin) ([]byte, error) {
+	lines := bytes.SplitAfter(b, []byte("\n"))
+
+	for _, pin := range pins {
+		if !pin.Outdated() {
+			continue
+		}
+
+		// swap the version in place rather than re-encoding the reference, which could reorder qualifiers
+		head, _, _ := strings.Cut(pin.Uses, "?")
+		head, _, _ = strings.Cut(head, "#")
+		at := strings.LastIndex(head, "@"+pin.Current)
+		if at < 0 {
+			return nil, fmt.Errorf("version %q not found in %q", pin.Current, pin.Uses)
+		}
+		next := pin.Uses[:at+1] + pin.Latest + pin.Uses[at+1+len(pin.Current):]
+
+		if pin.Line < 1 || pin.Line > len(lines) {
+			return nil, fmt.Errorf("line %d is out of range", pin.Line)
+		}
+
+		line := lines[pin.Line-1]
+		col := min(max(pin.Column-1, 0), len(line))
+
+		idx := bytes.Index(line[col:], []byte(pin.Uses))
+		if idx < 0 {
+			return nil, fmt.Errorf("%q not found on line %d", pin.Uses, pin.Line)
+		}
+		idx += col
+
+		updated := make([]byte, 0, len(line)+len(next)-len(pin.Uses))
+		updated = append(updated, line[:idx]...)
+		updated = append(updated, next...)
+		updated = append(updated, line[idx+len(pin.Uses):]...)
+		lines[pin.Line-1] = updated
+	}
+
+	return bytes.Join(lines, nil), nil
+}
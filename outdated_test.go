@@ -0,0 +1,115 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package vai
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPins(t *testing.T) {
+	tags := map[string][]string{
+		"/api/v1/repos/noxsios/vai/tags":   {"v1", "v1.0.0", "v1.2.0", "v2", "v2.0.0", "v2.1.0-rc.1", "v3", "nightly"},
+		"/api/v1/repos/noxsios/tasks/tags": {"1.0.0", "1.1.0"},
+		"/api/v1/repos/noxsios/none/tags":  {"nightly"},
+	}
+
+	requests := 0
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		list, ok := tags[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		var page []map[string]string
+		if r.URL.Query().Get("page") == "1" {
+			for _, tag := range list {
+				page = append(page, map[string]string{"name": tag})
+			}
+		}
+		_ = json.NewEncoder(w).Encode(append([]map[string]string{}, page...))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	t.Cleanup(func() {
+		server.Close()
+	})
+
+	t.Setenv("GITEA_TOKEN", "")
+
+	workflow := strings.ReplaceAll(`# yaml-language-server: $schema=vai.schema.json
+
+default:
+  # pinned, two versions behind
+  - uses: pkg:gitea/noxsios/vai@v1.0.0?base=BASE&task=a#vai.yaml
+  - uses: "pkg:gitea/noxsios/vai@v1.0.0?base=BASE&task=b#vai.yaml" # quoted
+  - uses: pkg:gitea/noxsios/tasks@1.1.0?base=BASE#vai.yaml
+  - uses: pkg:gitea/noxsios/vai@main?base=BASE#vai.yaml
+  - uses: pkg:gitea/noxsios/vai@^1?base=BASE#vai.yaml
+  - uses: pkg:gitea/noxsios/none@v0.1.0?base=BASE#vai.yaml
+  # floating, moved to each release on purpose
+  - uses: pkg:gitea/noxsios/vai@v1?base=BASE#vai.yaml
+  - uses: file:other.yaml
+  - uses: other
+
+other: [{run: "echo 'pkg:gitea/noxsios/vai@v1.0.0'"}]
+`, "BASE", server.URL)
+
+	ctx := context.Background()
+
+	pins, err := CheckPins(ctx, []byte(workflow), "file:vai.yaml")
+	require.NoError(t, err)
+	// one page of tags and one empty page per repository
+	require.Equal(t, 6, requests)
+
+	base := "?base=" + server.URL
+	require.Equal(t, []Pin{
+		{Uses: "pkg:gitea/noxsios/vai@v1.0.0" + base + "&task=a#vai.yaml", Line: 5, Column: 11, Current: "v1.0.0", Latest: "v2.0.0"},
+		{Uses: "pkg:gitea/noxsios/vai@v1.0.0" + base + "&task=b#vai.yaml", Line: 6, Column: 11, Current: "v1.0.0", Latest: "v2.0.0"},
+		{Uses: "pkg:gitea/noxsios/tasks@1.1.0" + base + "#vai.yaml", Line: 7, Column: 11, Current: "1.1.0", Latest: "1.1.0"},
+		{Uses: "pkg:gitea/noxsios/none@v0.1.0" + base + "#vai.yaml", Line: 10, Column: 11, Current: "v0.1.0", Latest: ""},
+	}, pins)
+
+	require.True(t, pins[0].Outdated())
+	require.False(t, Pin{Current: "v1", Latest: "v1.4.2"}.Outdated())
+	require.False(t, pins[2].Outdated())
+	require.False(t, pins[3].Outdated())
+
+	b, err := UpdatePins([]byte(workflow), pins)
+	require.NoError(t, err)
+
+	expected := strings.Replace(workflow, "vai@v1.0.0?base", "vai@v2.0.0?base", 2)
+	require.Equal(t, expected, string(b))
+
+	// the updated file has nothing left to update
+	pins, err = CheckPins(ctx, b, "file:vai.yaml")
+	require.NoError(t, err)
+	for _, pin := range pins {
+		require.False(t, pin.Outdated(), pin.Uses)
+	}
+
+	_, err = UpdatePins([]byte("default: []\n"), []Pin{{Uses: "pkg:gitea/noxsios/vai@v1.0.0", Line: 1, Current: "v1.0.0", Latest: "v2.0.0"}})
+	require.EqualError(t, err, `"pkg:gitea/noxsios/vai@v1.0.0" not found on line 1`)
+
+	// tags are listed from the mirror
+	mirrored := WithRewrites(ctx, Rewrites{{From: "pkg:github/*", To: "pkg:gitea/*?base=" + server.URL}})
+	pins, err = CheckPins(mirrored, []byte("default: [uses: pkg:github/noxsios/vai@v1.2.0#vai.yaml]"), "file:vai.yaml")
+	require.NoError(t, err)
+	require.Len(t, pins, 1)
+	require.Equal(t, "pkg:github/noxsios/vai@v1.2.0#vai.yaml", pins[0].Uses)
+	require.Equal(t, "v2.0.0", pins[0].Latest)
+
+	_, err = CheckPins(ctx, []byte("default: [uses: pkg:sourcehut/noxsios/vai@v1.0.0]"), "file:vai.yaml")
+	require.EqualError(t, err, `unsupported type: "sourcehut"`)
+
+	_, err = CheckPins(ctx, []byte("default: [uses: https://example.com/vai@v1.0.0]"), "file:vai.yaml")
+	require.NoError(t, err)
+}
@@ -0,0 +1,99 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package vai
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// LocalOverrideFileName returns the name of the override file applied to the workflow file at path, if it exists,
+// eg. vai.local.yaml for vai.yaml
+func LocalOverrideFileName(path string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + ".local" + ext
+}
+
+// ReadOverrides reads the override files at paths and merges them on top of wf in order.
+//
+// The result is not validated, as overrides are often incomplete on their own.
+func ReadOverrides(wf Workflow, paths ...string) (Workflow, error) {
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+
+		override, err := Read(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		wf = Merge(wf, override)
+	}
+	return wf, nil
+}
+
+// Merge returns a copy of wf with override merged on top of it.
+//
+// Tasks only in override are added. A task where every step in override has an `id` is merged step by step:
+// steps matching the `id` of an existing step are merged into it, the rest are appended. Any other task is replaced.
+func Merge(wf, override Workflow) Workflow {
+	merged := make(Workflow, len(wf)+len(override))
+	for name, task := range wf {
+		merged[name] = slices.Clone(task)
+	}
+
+	for name, task := range override {
+		existing, ok := merged[name]
+		if !ok || !identified(task) {
+			merged[name] = slices.Clone(task)
+			continue
+		}
+
+		for _, step := range task {
+			idx := slices.IndexFunc(existing, func(s Step) bool {
+				return s.ID == step.ID
+			})
+			if idx < 0 {
+				existing = append(existing, step)
+				continue
+			}
+			existing[idx] = mergeStep(existing[idx], step)
+		}
+		merged[name] = existing
+	}
+
+	return merged
+}
+
+// identified reports whether task has steps, and every step has an `id`.
+func identified(task Task) bool {
+	return len(task) > 0 && !slices.ContainsFunc(task, func(s Step) bool {
+		return s.ID == ""
+	})
+}
+
+// mergeStep returns step with the fields set in override, merging `with` key by key.
+//
+// Setting one of `run`, `script`, `eval` or `uses` replaces whichever the step had.
+func mergeStep(step, override Step) Step {
+	if override.Run != "" || override.Script != "" || override.Eval != "" || override.Uses != "" {
+		step.Run, step.Script, step.Eval, step.Uses = override.Run, override.Script, override.Eval, override.Uses
+	}
+	if override.Name != "" {
+		step.Name = override.Name
+	}
+	if len(override.With) > 0 {
+		with := make(With, len(step.With)+len(override.With))
+		maps.Copy(with, step.With)
+		maps.Copy(with, override.With)
+		step.With = with
+	}
+	return step
+}
@@ -0,0 +1,105 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package vai
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalOverrideFileName(t *testing.T) {
+	require.Equal(t, "vai.local.yaml", LocalOverrideFileName("vai.yaml"))
+	require.Equal(t, "ci/tasks.local.yml", LocalOverrideFileName("ci/tasks.yml"))
+}
+
+func TestMerge(t *testing.T) {
+	wf := Workflow{
+		"test": {
+			{Run: "go test ./...", ID: "unit", With: With{"short": "false", "race": "true"}},
+			{Run: "echo done"},
+		},
+		"build": {{Run: "go build ./..."}},
+		"lint":  {{Run: "golangci-lint run", ID: "lint"}},
+	}
+
+	testCases := []struct {
+		name     string
+		override Workflow
+		expected Workflow
+	}{
+		{
+			"no override",
+			Workflow{},
+			wf,
+		},
+		{
+			"add a task",
+			Workflow{"docs": {{Run: "hugo"}}},
+			Workflow{
+				"test":  wf["test"],
+				"build": wf["build"],
+				"lint":  wf["lint"],
+				"docs":  {{Run: "hugo"}},
+			},
+		},
+		{
+			"replace a task",
+			Workflow{"build": {{Run: "go build -race ./..."}}},
+			Workflow{
+				"test":  wf["test"],
+				"build": {{Run: "go build -race ./..."}},
+				"lint":  wf["lint"],
+			},
+		},
+		{
+			"override with defaults",
+			Workflow{"test": {{ID: "unit", With: With{"short": "true"}}}},
+			Workflow{
+				"test": {
+					{Run: "go test ./...", ID: "unit", With: With{"short": "true", "race": "true"}},
+					{Run: "echo done"},
+				},
+				"build": wf["build"],
+				"lint":  wf["lint"],
+			},
+		},
+		{
+			"replace and add steps",
+			Workflow{"lint": {{ID: "lint", Uses: "file:lint.yaml"}, {ID: "vet", Run: "go vet ./..."}}},
+			Workflow{
+				"test":  wf["test"],
+				"build": wf["build"],
+				"lint":  {{Uses: "file:lint.yaml", ID: "lint"}, {Run: "go vet ./...", ID: "vet"}},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, Merge(wf, tc.override))
+		})
+	}
+
+	// the original workflow is left untouched
+	require.Equal(t, With{"short": "false", "race": "true"}, wf["test"][0].With)
+	require.Len(t, wf["lint"], 1)
+}
+
+func TestReadOverrides(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.yaml")
+	b := filepath.Join(dir, "b.yaml")
+	require.NoError(t, os.WriteFile(a, []byte("default:\n  - run: echo a\n"), 0644))
+	require.NoError(t, os.WriteFile(b, []byte("default:\n  - run: echo b\n"), 0644))
+
+	wf, err := ReadOverrides(Workflow{"default": {{Run: "echo"}}}, a, b)
+	require.NoError(t, err)
+	require.Equal(t, Workflow{"default": {{Run: "echo b"}}}, wf)
+
+	_, err = ReadOverrides(wf, filepath.Join(dir, "dne.yaml"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,293 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package vai
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/package-url/packageurl-go"
+)
+
+// PolicyEnvVar is the environment variable for the path to the policy file.
+const PolicyEnvVar = "VAI_POLICY"
+
+// Patterns is a pair of allow and deny lists.
+//
+// Entries are matched with path.Match, so * matches within a single path segment.
+// Deny wins over allow, and an empty allow list allows everything that is not denied.
+type Patterns struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+// Policy restricts which remote sources `uses` may reference.
+type Policy struct {
+	// Schemes restricts URL schemes (eg. https, pkg, oci, git+ssh), use deny: [http] to forbid plain http
+	Schemes Patterns `json:"schemes,omitempty"`
+	// Hosts restricts the hosts remote references point at, including the host of a pkg: base qualifier
+	Hosts Patterns `json:"hosts,omitempty"`
+	// Namespaces restricts pkg: references by type/namespace/name (eg. github/acme/*)
+	Namespaces Patterns `json:"namespaces,omitempty"`
+	// Pinning requires remote references to be pinned to a commit SHA or digest
+	Pinning Pinning `json:"pinning,omitempty"`
+}
+
+// Pinning requires remote references to be pinned to immutable content.
+type Pinning struct {
+	// Require pinning of every remote reference
+	Require bool `json:"require,omitempty"`
+	// Except references matching these patterns, matched against type/namespace/name for pkg: references and the host otherwise
+	Except []string `json:"except,omitempty"`
+}
+
+// ReadPolicy reads the policy file at name.
+func ReadPolicy(name string) (Policy, error) {
+	var p Policy
+
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return p, err
+	}
+
+	if err := yaml.UnmarshalWithOptions(b, &p, yaml.DisallowUnknownField()); err != nil {
+		return p, fmt.Errorf("%s: %w", name, err)
+	}
+
+	for _, patterns := range [][]string{p.Schemes.Allow, p.Schemes.Deny, p.Hosts.Allow, p.Hosts.Deny, p.Namespaces.Allow, p.Namespaces.Deny, p.Pinning.Except} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return p, fmt.Errorf("%s: invalid pattern %q: %w", name, pattern, err)
+			}
+		}
+	}
+
+	return p, nil
+}
+
+type policyKey struct{}
+
+// WithPolicy returns a copy of ctx in which every `uses` reference must satisfy p.
+func WithPolicy(ctx context.Context, p Policy) context.Context {
+	return context.WithValue(ctx, policyKey{}, p)
+}
+
+func policyFromContext(ctx context.Context) (Policy, bool) {
+	p, ok := ctx.Value(policyKey{}).(Policy)
+	return p, ok
+}
+
+// match reports whether s matches any of patterns.
+func match(patterns []string, s string) bool {
+	return slices.ContainsFunc(patterns, func(pattern string) bool {
+		ok, _ := path.Match(pattern, s)
+		return ok
+	})
+}
+
+// check returns an error if s is denied, or not allowed by a non-empty allow list.
+func (p Patterns) check(kind, s string) error {
+	if match(p.Deny, s) {
+		return fmt.Errorf("%s %q is denied", kind, s)
+	}
+	if len(p.Allow) > 0 && !match(p.Allow, s) {
+		return fmt.Errorf("%s %q is not allowed", kind, s)
+	}
+	return nil
+}
+
+// defaultHosts are the hosts pkg: types point at when no base qualifier is given.
+var defaultHosts = map[string]string{
+	"github":    "github.com",
+	"gitlab":    "gitlab.com",
+	"bitbucket": "bitbucket.org",
+	"gitea":     "gitea.com",
+}
+
+var (
+	commitPattern = regexp.MustCompile("^[0-9a-f]{40}([0-9a-f]{24})?$")
+	digestPattern = regexp.MustCompile("@sha256:[0-9a-f]{64}$")
+)
+
+// Check returns an error if the fully resolved reference location is not allowed by the policy.
+func (p Policy) Check(location string) error {
+	if err := p.check(location); err != nil {
+		return fmt.Errorf("%s is not allowed by policy: %w", location, err)
+	}
+	return nil
+}
+
+// source is what a policy knows about a fully resolved reference location.
+type source struct {
+	scheme string
+	// host is empty for local files
+	host string
+	// namespace is type/namespace/name for pkg: references, empty otherwise
+	namespace string
+	// pinned reports whether the reference is pinned to immutable content
+	pinned bool
+}
+
+// subject is what pinning exceptions and signature rules are matched against.
+func (s source) subject() string {
+	if s.namespace != "" {
+		return s.namespace
+	}
+	return s.host
+}
+
+func parseSource(location string) (source, error) {
+	uri, err := url.Parse(location)
+	if err != nil {
+		return source{}, err
+	}
+
+	src := source{scheme: uri.Scheme}
+
+	switch {
+	case uri.Scheme == "file":
+		// local files are not remote sources
+	case uri.Scheme == "pkg":
+		pURL, err := packageurl.FromString(location)
+		if err != nil {
+			return source{}, err
+		}
+
+		src.namespace = pURL.Type + "/" + pURL.Name
+		if pURL.Namespace != "" {
+			src.namespace = pURL.Type + "/" + pURL.Namespace + "/" + pURL.Name
+		}
+
+		qualifiers := pURL.Qualifiers.Map()
+		switch {
+		case qualifiers["base"] != "":
+			base, err := url.Parse(qualifiers["base"])
+			if err != nil {
+				return source{}, err
+			}
+			src.host = base.Hostname()
+		case qualifiers["vcs_url"] != "":
+			vcs, err := url.Parse(qualifiers["vcs_url"])
+			if err != nil {
+				return source{}, err
+			}
+			src.host = vcs.Hostname()
+		default:
+			src.host = defaultHosts[pURL.Type]
+		}
+
+		src.pinned = commitPattern.MatchString(pURL.Version)
+	case uri.Scheme == "oci":
+		src.host = uri.Hostname()
+		src.pinned = digestPattern.MatchString(uri.Path)
+	case strings.HasPrefix(uri.Scheme, "git+"):
+		src.host = uri.Hostname()
+		_, ref, _ := strings.Cut(uri.Path, "@")
+		src.pinned = commitPattern.MatchString(ref)
+	default:
+		// http(s) content can change at any time, and cannot be pinned
+		src.host = uri.Hostname()
+	}
+
+	return src, nil
+}
+
+func (p Policy) check(location string) error {
+	src, err := parseSource(location)
+	if err != nil {
+		return err
+	}
+
+	if err := p.Schemes.check("scheme", src.scheme); err != nil {
+		return err
+	}
+
+	if src.scheme == "file" {
+		return nil
+	}
+
+	if err := p.Hosts.check("host", src.host); err != nil {
+		return err
+	}
+
+	if src.namespace != "" {
+		if err := p.Namespaces.check("namespace", src.namespace); err != nil {
+			return err
+		}
+	}
+
+	if p.Pinning.Require && !src.pinned && !match(p.Pinning.Except, src.subject()) {
+		return fmt.Errorf("reference is not pinned to a commit SHA or digest")
+	}
+
+	return nil
+}
+
+// ValidatePolicy statically checks every include, `uses` and `script` reference in wf against the policy in ctx, if any.
+//
+// References are resolved against origin, and replacements and rewrite rules are applied,
+// so the same locations are checked as when the workflow is run.
+func ValidatePolicy(ctx context.Context, wf Workflow, origin string) error {
+	p, ok := policyFromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	check := func(u string) error {
+		ref, err := resolve(ctx, u, origin)
+		if err != nil {
+			return err
+		}
+
+		ref, err = redirect(ctx, ref)
+		if err != nil {
+			return err
+		}
+
+		return p.Check(ref.location)
+	}
+
+	includes := wf.Includes()
+	namespaces := make([]string, 0, len(includes))
+	for ns := range includes {
+		namespaces = append(namespaces, ns)
+	}
+	slices.Sort(namespaces)
+
+	for _, ns := range namespaces {
+		if err := check(includes[ns]); err != nil {
+			return fmt.Errorf("include %q %w", IncludeKey(ns), err)
+		}
+	}
+
+	for _, name := range wf.OrderedTaskNames() {
+		for idx, step := range wf[name] {
+			if step.Script != "" {
+				if err := check(step.Script); err != nil {
+					return fmt.Errorf(".%s[%d].script %w", name, idx, err)
+				}
+				continue
+			}
+			if step.Uses == "" {
+				continue
+			}
+			if wf.local(step.Uses) {
+				continue
+			}
+
+			if err := check(step.Uses); err != nil {
+				return fmt.Errorf(".%s[%d].uses %w", name, idx, err)
+			}
+		}
+	}
+
+	return nil
+}
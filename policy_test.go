@@ -0,0 +1,168 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package vai
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicy(t *testing.T) {
+	sha := "0123456789abcdef0123456789abcdef01234567"
+	digest := "sha256:" + sha + "0123456789abcdef01234567"
+
+	p := Policy{
+		Schemes:    Patterns{Deny: []string{"http"}},
+		Hosts:      Patterns{Allow: []string{"github.com", "*.internal"}, Deny: []string{"blocked.internal"}},
+		Namespaces: Patterns{Deny: []string{"github/evil/*"}},
+		Pinning:    Pinning{Require: true, Except: []string{"github/acme/*", "raw.internal"}},
+	}
+
+	testCases := []struct {
+		location    string
+		expectedErr string
+	}{
+		{
+			location: "file:vai.yaml",
+		},
+		{
+			location: "pkg:github/other/tasks@" + sha + "#vai.yaml",
+		},
+		{
+			location: "pkg:github/acme/tasks@main#vai.yaml",
+		},
+		{
+			location: "pkg:gitlab/group/tasks@" + sha + "?base=https://git.internal#vai.yaml",
+		},
+		{
+			location: "oci://registry.internal/tasks@" + digest + "#vai.yaml",
+		},
+		{
+			location: "git+https://git.internal/org/repo.git@" + sha + "#vai.yaml",
+		},
+		{
+			location: "https://raw.internal/vai.yaml",
+		},
+		{
+			location:    "http://raw.internal/vai.yaml",
+			expectedErr: `http://raw.internal/vai.yaml is not allowed by policy: scheme "http" is denied`,
+		},
+		{
+			location:    "https://example.com/vai.yaml",
+			expectedErr: `https://example.com/vai.yaml is not allowed by policy: host "example.com" is not allowed`,
+		},
+		{
+			location:    "pkg:gitlab/group/tasks@" + sha + "#vai.yaml",
+			expectedErr: `pkg:gitlab/group/tasks@` + sha + `#vai.yaml is not allowed by policy: host "gitlab.com" is not allowed`,
+		},
+		{
+			location:    "oci://blocked.internal/tasks@" + digest + "#vai.yaml",
+			expectedErr: `oci://blocked.internal/tasks@` + digest + `#vai.yaml is not allowed by policy: host "blocked.internal" is denied`,
+		},
+		{
+			location:    "pkg:github/evil/tasks@" + sha + "#vai.yaml",
+			expectedErr: `pkg:github/evil/tasks@` + sha + `#vai.yaml is not allowed by policy: namespace "github/evil/tasks" is denied`,
+		},
+		{
+			location:    "pkg:github/other/tasks@v1.0.0#vai.yaml",
+			expectedErr: "pkg:github/other/tasks@v1.0.0#vai.yaml is not allowed by policy: reference is not pinned to a commit SHA or digest",
+		},
+		{
+			location:    "oci://registry.internal/tasks:v1#vai.yaml",
+			expectedErr: "oci://registry.internal/tasks:v1#vai.yaml is not allowed by policy: reference is not pinned to a commit SHA or digest",
+		},
+		{
+			location:    "git+https://git.internal/org/repo.git@main#vai.yaml",
+			expectedErr: "git+https://git.internal/org/repo.git@main#vai.yaml is not allowed by policy: reference is not pinned to a commit SHA or digest",
+		},
+		{
+			location:    "https://files.internal/vai.yaml",
+			expectedErr: "https://files.internal/vai.yaml is not allowed by policy: reference is not pinned to a commit SHA or digest",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.location, func(t *testing.T) {
+			err := p.Check(tc.location)
+			if tc.expectedErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tc.expectedErr)
+			}
+		})
+	}
+
+	// an empty policy allows everything
+	require.NoError(t, Policy{}.Check("http://example.com/vai.yaml"))
+}
+
+func TestValidatePolicy(t *testing.T) {
+	wf := Workflow{
+		"default": {
+			{Uses: "file:other.yaml"},
+			{Uses: "other"},
+		},
+		"other": {
+			{Uses: "pkg:github/acme/tasks@v1.0.0"},
+		},
+	}
+
+	scripts := Workflow{
+		"default": {
+			{Script: "https://example.com/build.sh"},
+		},
+	}
+
+	// no policy, nothing to check
+	require.NoError(t, ValidatePolicy(context.Background(), wf, "file:vai.yaml"))
+
+	ctx := WithPolicy(context.Background(), Policy{Namespaces: Patterns{Allow: []string{"github/noxsios/*"}}})
+	err := ValidatePolicy(ctx, wf, "file:vai.yaml")
+	require.EqualError(t, err, `.other[0].uses pkg:github/acme/tasks@v1.0.0#vai.yaml is not allowed by policy: namespace "github/acme/tasks" is not allowed`)
+
+	// the replacement is checked, not the original reference
+	ctx = WithReplacements(ctx, Replacements{"pkg:github/acme/tasks": "pkg:github/noxsios/tasks"})
+	require.NoError(t, ValidatePolicy(ctx, wf, "file:vai.yaml"))
+
+	ctx = WithPolicy(context.Background(), Policy{Schemes: Patterns{Deny: []string{"https"}}})
+	err = ValidatePolicy(ctx, scripts, "file:vai.yaml")
+	require.EqualError(t, err, `.default[0].script https://example.com/build.sh is not allowed by policy: scheme "https" is denied`)
+}
+
+func TestReadPolicy(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "policy.yaml")
+
+	_, err := ReadPolicy(p)
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	require.NoError(t, os.WriteFile(p, []byte(`schemes:
+  deny: [http]
+hosts:
+  allow: [github.com, "*.internal"]
+pinning:
+  require: true
+  except: [github/acme/*]
+`), 0644))
+
+	policy, err := ReadPolicy(p)
+	require.NoError(t, err)
+	require.Equal(t, Policy{
+		Schemes: Patterns{Deny: []string{"http"}},
+		Hosts:   Patterns{Allow: []string{"github.com", "*.internal"}},
+		Pinning: Pinning{Require: true, Except: []string{"github/acme/*"}},
+	}, policy)
+
+	require.NoError(t, os.WriteFile(p, []byte("hosts: {allow: [\"[\"]}\n"), 0644))
+	_, err = ReadPolicy(p)
+	require.EqualError(t, err, p+`: invalid pattern "[": syntax error in pattern`)
+
+	require.NoError(t, os.WriteFile(p, []byte("unknown: true\n"), 0644))
+	_, err = ReadPolicy(p)
+	require.ErrorContains(t, err, `unknown field "unknown"`)
+}
@@ -0,0 +1,367 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package vai
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strings"
+	"sync"
+
+	"github.com/noxsios/vai/uses"
+)
+
+const (
+	// DefaultConcurrency is the default number of references fetched at the same time.
+	DefaultConcurrency = 8
+	// DefaultHostConcurrency is the default number of references fetched at the same time from a single host.
+	DefaultHostConcurrency = 4
+)
+
+// FetchLimits bounds the number of references fetched at the same time while prefetching.
+type FetchLimits struct {
+	// Total is the number of references fetched at the same time
+	Total int
+	// PerHost is the number of references fetched at the same time from a single host
+	PerHost int
+}
+
+type fetchLimitsKey struct{}
+
+// WithFetchLimits returns a copy of ctx in which prefetching is bounded by limits.
+func WithFetchLimits(ctx context.Context, limits FetchLimits) context.Context {
+	return context.WithValue(ctx, fetchLimitsKey{}, limits)
+}
+
+func fetchLimitsFromContext(ctx context.Context) FetchLimits {
+	limits, _ := ctx.Value(fetchLimitsKey{}).(FetchLimits)
+	if limits.Total <= 0 {
+		limits.Total = DefaultConcurrency
+	}
+	if limits.PerHost <= 0 {
+		limits.PerHost = DefaultHostConcurrency
+	}
+	return limits
+}
+
+type plannedKey struct{}
+
+func plannedFromContext(ctx context.Context) bool {
+	planned, _ := ctx.Value(plannedKey{}).(bool)
+	return planned
+}
+
+// Plan resolves the `uses` graph of the given tasks in wf up front, fetching every remote reference in it concurrently,
+// and returns a copy of ctx in which the tasks are run from the store.
+//
+// Local files are not planned, as an earlier step may write them, and are read when they are reached instead.
+// Contexts that already have a ref index, eg. from a bundle, are returned as is.
+func Plan(ctx context.Context, store *uses.Store, wf Workflow, origin string, tasks []string) (context.Context, error) {
+	if _, ok := refIndexFromContext(ctx); ok {
+		return ctx, nil
+	}
+
+	refs, err := prefetch(ctx, store, wf, origin, tasks, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return context.WithValue(WithRefIndex(ctx, refs), plannedKey{}, true), nil
+}
+
+// Prefetch resolves the `uses` graph of the given tasks in wf and fetches every remote workflow,
+// script and signature in it into the store, without running anything.
+//
+// Independent references are fetched concurrently, bounded by the FetchLimits in ctx.
+// It returns every reference it encountered.
+func Prefetch(ctx context.Context, store *uses.Store, wf Workflow, origin string, tasks []string) (uses.RefIndex, error) {
+	return prefetch(ctx, store, wf, origin, tasks, true)
+}
+
+// prefetch walks the `uses` graph of the given tasks in wf, following local files only if local is set.
+func prefetch(ctx context.Context, store *uses.Store, wf Workflow, origin string, tasks []string, local bool) (uses.RefIndex, error) {
+	limits := fetchLimitsFromContext(ctx)
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	p := &prefetcher{
+		store:   store,
+		local:   local,
+		cancel:  cancel,
+		sem:     make(chan struct{}, limits.Total),
+		perHost: limits.PerHost,
+		hosts:   make(map[string]chan struct{}),
+		refs:    make(uses.RefIndex),
+		seen:    make(map[[2]string]bool),
+		loads:   make(map[string]*loadResult),
+	}
+
+	for _, task := range tasks {
+		p.walk(ctx, wf, task, origin)
+	}
+	p.wg.Wait()
+
+	if err := context.Cause(ctx); err != nil {
+		return nil, err
+	}
+	return p.refs, nil
+}
+
+// loadResult is a workflow loaded once, no matter how many steps reference it.
+type loadResult struct {
+	once sync.Once
+	wf   Workflow
+	err  error
+}
+
+// prefetcher walks the `uses` graph of a workflow concurrently, recording every reference it encounters.
+type prefetcher struct {
+	store *uses.Store
+	// local is set if local files are followed too
+	local  bool
+	cancel context.CancelCauseFunc
+	// sem bounds the number of concurrent fetches
+	sem     chan struct{}
+	perHost int
+	wg      sync.WaitGroup
+
+	mu sync.Mutex
+	// hosts bounds the number of concurrent fetches per host
+	hosts map[string]chan struct{}
+	refs  uses.RefIndex
+	// seen tracks visited (origin, task) pairs
+	seen map[[2]string]bool
+	// loads tracks workflows by location
+	loads map[string]*loadResult
+}
+
+// visit reports whether the task at origin has not been walked yet, marking it as walked.
+func (p *prefetcher) visit(origin, task string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := [2]string{origin, task}
+	if p.seen[key] {
+		return false
+	}
+	p.seen[key] = true
+	return true
+}
+
+// record adds refs to the references encountered so far.
+func (p *prefetcher) record(refs uses.RefIndex) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	maps.Copy(p.refs, refs)
+}
+
+// fail stops the walk, keeping the first error.
+func (p *prefetcher) fail(err error) {
+	p.cancel(err)
+}
+
+// spawn runs fn in a new goroutine, stopping the walk if it fails.
+func (p *prefetcher) spawn(fn func() error) {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		if err := fn(); err != nil {
+			p.fail(err)
+		}
+	}()
+}
+
+// limit runs fn while holding a slot for the host that u resolves to, and a slot of the total,
+// bounding the number of concurrent fetches.
+func (p *prefetcher) limit(ctx context.Context, u, origin string, fn func() error) error {
+	host, err := p.host(ctx, u, origin)
+	if err != nil {
+		return err
+	}
+
+	// the host slot is taken first, so waiting on a busy host does not hold up other hosts
+	if host != "" {
+		p.mu.Lock()
+		sem, ok := p.hosts[host]
+		if !ok {
+			sem = make(chan struct{}, p.perHost)
+			p.hosts[host] = sem
+		}
+		p.mu.Unlock()
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		}
+		defer func() { <-sem }()
+	}
+
+	select {
+	case p.sem <- struct{}{}:
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+	defer func() { <-p.sem }()
+
+	return fn()
+}
+
+// host returns the host that u, relative to origin, is fetched from, or an empty string for local files.
+func (p *prefetcher) host(ctx context.Context, u, origin string) (string, error) {
+	ref, err := resolve(ctx, u, origin)
+	if err != nil {
+		return "", err
+	}
+
+	ref, err = redirect(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+
+	src, err := parseSource(ref.location)
+	if err != nil {
+		return "", err
+	}
+	return src.host, nil
+}
+
+// follows reports whether ref is fetched while walking, local files are only fetched if local is set.
+func (p *prefetcher) follows(ref reference) bool {
+	return p.local || !strings.HasPrefix(ref.location, "file:")
+}
+
+// load loads the workflow at ref, sharing the result with any other step referencing the same location.
+func (p *prefetcher) load(ctx context.Context, ref reference) (Workflow, error) {
+	p.mu.Lock()
+	r, ok := p.loads[ref.location]
+	if !ok {
+		r = &loadResult{}
+		p.loads[ref.location] = r
+	}
+	p.mu.Unlock()
+
+	r.once.Do(func() {
+		var refs uses.RefIndex
+		r.wf, refs, r.err = load(ctx, p.store, ref)
+		if r.err == nil {
+			p.record(refs)
+		}
+	})
+	return r.wf, r.err
+}
+
+func (p *prefetcher) walk(ctx context.Context, wf Workflow, taskName, origin string) {
+	if taskName == "" {
+		taskName = DefaultTaskName
+	}
+
+	if ctx.Err() != nil || !p.visit(origin, taskName) {
+		return
+	}
+
+	task, ok := wf.Find(taskName)
+	if !ok {
+		ref, sub, ok := wf.include(taskName)
+		if !ok {
+			p.fail(fmt.Errorf("task %q not found", taskName))
+			return
+		}
+
+		// `<namespace>:*` walks every task of the included workflow
+		if sub == "*" {
+			p.follow(ctx, ref, origin, true)
+			return
+		}
+
+		u, err := withTask(ref, sub)
+		if err != nil {
+			p.fail(err)
+			return
+		}
+		p.follow(ctx, u, origin, false)
+		return
+	}
+
+	for _, step := range task {
+		if step.Script != "" {
+			p.spawn(func() error {
+				return p.limit(ctx, step.Script, origin, func() error {
+					ref, err := locate(ctx, p.store, step.Script, origin)
+					if err != nil {
+						return err
+					}
+
+					if !p.follows(ref) {
+						return nil
+					}
+
+					_, refs, err := read(ctx, p.store, ref)
+					if err != nil {
+						return err
+					}
+
+					p.record(refs)
+					return nil
+				})
+			})
+			continue
+		}
+
+		if step.Uses == "" {
+			continue
+		}
+
+		if wf.local(step.Uses) {
+			p.walk(ctx, wf, step.Uses, origin)
+			continue
+		}
+
+		p.follow(ctx, step.Uses, origin, false)
+	}
+}
+
+// follow loads the workflow referenced by u and walks the task it calls, or every task in it if all is set.
+func (p *prefetcher) follow(ctx context.Context, u, origin string, all bool) {
+	p.spawn(func() error {
+		var ref reference
+		var next Workflow
+		var skipped bool
+
+		err := p.limit(ctx, u, origin, func() error {
+			var err error
+			ref, err = locate(ctx, p.store, u, origin)
+			if err != nil {
+				return err
+			}
+
+			if !p.follows(ref) {
+				skipped = true
+				return nil
+			}
+
+			next, err = p.load(ctx, ref)
+			return err
+		})
+		if err != nil || skipped {
+			return err
+		}
+
+		// the next workflow is walked outside of the semaphore, so its steps can take the slot
+		if !all {
+			p.walk(ctx, next, ref.task, ref.origin)
+			return nil
+		}
+
+		for _, name := range next.OrderedTaskNames() {
+			p.walk(ctx, next, name, ref.origin)
+		}
+		for ns := range next.Includes() {
+			p.walk(ctx, next, IncludeKey(ns), ref.origin)
+		}
+		return nil
+	})
+}
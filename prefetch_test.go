@@ -0,0 +1,173 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package vai
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/noxsios/vai/uses"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrefetch(t *testing.T) {
+	files := map[string]string{
+		"/a.yaml":       "default:\n  - run: exit 1\n  - uses: file:common.yaml?task=shared\n  - script: file:scripts/a.sh\n",
+		"/b.yaml":       "default:\n  - uses: file:common.yaml?task=shared\n  - uses: local\nlocal:\n  - uses: file:c.yaml\n",
+		"/c.yaml":       "default:\n  - run: exit 1\n",
+		"/common.yaml":  "shared:\n  - run: exit 1\n",
+		"/scripts/a.sh": "exit 1\n",
+	}
+
+	var mu sync.Mutex
+	var requested []string
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested = append(requested, r.URL.Path)
+		mu.Unlock()
+
+		b, ok := files[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(b))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+
+	ctx := context.Background()
+	store, err := uses.NewStore(afero.NewMemMapFs())
+	require.NoError(t, err)
+
+	wf := Workflow{
+		"default": {
+			{Uses: server.URL + "/a.yaml"},
+			{Uses: server.URL + "/b.yaml"},
+		},
+		"missing": {
+			{Uses: server.URL + "/dne.yaml"},
+		},
+	}
+
+	// nothing is run, so the failing steps are never reached
+	refs, err := Prefetch(ctx, store, wf, "file:vai.yaml", []string{"default"})
+	require.NoError(t, err)
+
+	for _, p := range []string{"/a.yaml", "/b.yaml", "/c.yaml", "/common.yaml", "/scripts/a.sh"} {
+		require.Contains(t, refs, server.URL+p)
+		require.True(t, slices.Contains(requested, p), "%s was not fetched", p)
+	}
+	require.Len(t, refs, 5)
+
+	_, err = Prefetch(ctx, store, wf, "file:vai.yaml", []string{"missing"})
+	require.Error(t, err)
+
+	_, err = Prefetch(ctx, store, wf, "file:vai.yaml", []string{"dne"})
+	require.EqualError(t, err, `task "dne" not found`)
+
+	// everything needed to run is now in the store
+	server.Close()
+	ctx = WithRefIndex(ctx, refs)
+	require.NoError(t, Run(ctx, store, wf, "", With{}, "file:vai.yaml", true))
+}
+
+func TestPrefetchLimits(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+
+	handler := func(w http.ResponseWriter, _ *http.Request) {
+		mu.Lock()
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+
+		_, _ = w.Write([]byte("default:\n  - run: exit 1\n"))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	wf := Workflow{"default": {}}
+	for i := range 10 {
+		wf["default"] = append(wf["default"], Step{Uses: fmt.Sprintf("%s/%d.yaml", server.URL, i)})
+	}
+
+	for _, limits := range []FetchLimits{{Total: 1, PerHost: 8}, {Total: 8, PerHost: 2}} {
+		store, err := uses.NewStore(afero.NewMemMapFs())
+		require.NoError(t, err)
+
+		maxInFlight = 0
+		ctx := WithFetchLimits(context.Background(), limits)
+		refs, err := Prefetch(ctx, store, wf, "file:vai.yaml", []string{"default"})
+		require.NoError(t, err)
+		require.Len(t, refs, 10)
+		require.LessOrEqual(t, maxInFlight, min(limits.Total, limits.PerHost))
+	}
+}
+
+func TestPlan(t *testing.T) {
+	content := "default:\n  - run: echo 'planned'\n"
+
+	handler := func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(content))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+
+	store, err := uses.NewStore(afero.NewMemMapFs())
+	require.NoError(t, err)
+
+	wf := Workflow{"default": {{Uses: server.URL + "/vai.yaml"}}}
+
+	ctx, err := Plan(context.Background(), store, wf, "file:vai.yaml", []string{"default"})
+	require.NoError(t, err)
+
+	refs, ok := refIndexFromContext(ctx)
+	require.True(t, ok)
+	require.Contains(t, refs, server.URL+"/vai.yaml")
+
+	// the planned content is run, even once the server is gone
+	server.Close()
+	require.NoError(t, Run(ctx, store, wf, "", With{}, "file:vai.yaml", false))
+
+	// local files are read when they are reached, as an earlier step may write them
+	generated := filepath.Join(t.TempDir(), "gen.yaml")
+	wf = Workflow{"default": {
+		{Uses: server.URL + "/vai.yaml"},
+		{Uses: "file:" + generated},
+	}}
+	server = httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+	wf["default"][0].Uses = server.URL + "/vai.yaml"
+
+	planned, err := Plan(context.Background(), store, wf, "file:vai.yaml", []string{"default"})
+	require.NoError(t, err)
+	refs, _ = refIndexFromContext(planned)
+	require.Len(t, refs, 1)
+
+	require.NoError(t, os.WriteFile(generated, []byte("default:\n  - run: echo 'generated'\n"), 0644))
+	require.NoError(t, Run(planned, store, wf, "", With{}, "file:vai.yaml", false))
+
+	// an existing ref index is kept
+	again, err := Plan(ctx, store, wf, "file:vai.yaml", []string{"default"})
+	require.NoError(t, err)
+	require.Equal(t, ctx, again)
+
+	_, err = Plan(context.Background(), store, wf, "file:vai.yaml", []string{"dne"})
+	require.EqualError(t, err, `task "dne" not found`)
+}
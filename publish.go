@@ -0,0 +1,133 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package vai
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/charmbracelet/log"
+	"github.com/noxsios/vai/uses"
+)
+
+// Publish pushes the workflow at path to an OCI registry, along with every local workflow and script
+// it references through relative `file:` uses and scripts, returning the digest of the pushed artifact.
+//
+// Files are stored relative to the directory of the workflow at path, so relative references
+// continue to resolve once the artifact is pulled.
+func Publish(ctx context.Context, path, dst string) (string, error) {
+	logger := log.FromContext(ctx)
+
+	files, err := collectLocal(path)
+	if err != nil {
+		return "", err
+	}
+
+	for p := range files {
+		logger.Debug("publishing", "file", p, "to", dst)
+	}
+
+	return uses.NewOCIClient().Publish(ctx, dst, files)
+}
+
+// collectLocal reads the workflow at path and every workflow and script it references through relative `file:` uses
+// and scripts.
+//
+// The returned map is keyed by slash separated paths relative to the directory of the workflow at path.
+func collectLocal(path string) (map[string][]byte, error) {
+	dir := filepath.Dir(path)
+	files := make(map[string][]byte)
+
+	// local returns the path of a relative `file:` reference, relative to dir, or false for any other reference
+	local := func(rel, ref string) (string, bool, error) {
+		u, err := url.Parse(ref)
+		if err != nil {
+			return "", false, err
+		}
+
+		if u.Scheme != "file" {
+			return "", false, nil
+		}
+
+		if u.Opaque == "" {
+			return "", false, fmt.Errorf("%s references %q, only relative paths can be published", rel, ref)
+		}
+
+		next := filepath.Join(filepath.Dir(rel), u.Opaque)
+		if next == "." {
+			next = DefaultFileName
+		}
+
+		if !filepath.IsLocal(next) {
+			return "", false, fmt.Errorf("%s references %q, which is outside of %s", rel, ref, dir)
+		}
+
+		return next, true, nil
+	}
+
+	var visit func(rel string) error
+	visit = func(rel string) error {
+		key := filepath.ToSlash(rel)
+		if _, ok := files[key]; ok {
+			return nil
+		}
+
+		b, err := os.ReadFile(filepath.Join(dir, rel))
+		if err != nil {
+			return err
+		}
+
+		wf, err := ReadAndValidate(bytes.NewReader(b))
+		if err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+
+		files[key] = b
+
+		for _, task := range wf {
+			for _, step := range task {
+				if step.Script != "" {
+					next, ok, err := local(rel, step.Script)
+					if err != nil {
+						return err
+					}
+					if !ok {
+						continue
+					}
+
+					b, err := os.ReadFile(filepath.Join(dir, next))
+					if err != nil {
+						return err
+					}
+					files[filepath.ToSlash(next)] = b
+					continue
+				}
+
+				if step.Uses == "" || wf.local(step.Uses) {
+					continue
+				}
+
+				next, ok, err := local(rel, step.Uses)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					continue
+				}
+
+				if err := visit(next); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}
+
+	return files, visit(filepath.Base(path))
+}
@@ -0,0 +1,94 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package vai
+
+import (
+	"context"
+	"io"
+	"log"
+	"maps"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/noxsios/vai/uses"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublish(t *testing.T) {
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(server.Close)
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	write := func(name, content string) {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0644))
+	}
+
+	write("vai.yaml", `
+default:
+  - uses: file:tasks/echo.yaml?task=echo
+
+remote:
+  - uses: pkg:github/noxsios/vai@main?task=echo#testdata/simple.yaml
+`)
+	write("tasks/echo.yaml", `
+echo:
+  - run: echo "echo"
+  - uses: file:../shared/vai.yaml?task=hello
+`)
+	write("shared/vai.yaml", `
+hello:
+  - run: echo "hello"
+`)
+	write("unused.yaml", `
+unused:
+  - run: echo "unused"
+`)
+
+	files, err := collectLocal(filepath.Join(dir, "vai.yaml"))
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"vai.yaml", "tasks/echo.yaml", "shared/vai.yaml"}, slices.Collect(maps.Keys(files)))
+
+	dst := "oci://" + host + "/vai/tasks:v1"
+	digest, err := Publish(ctx, filepath.Join(dir, "vai.yaml"), dst)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(digest, "sha256:"))
+
+	store, err := uses.NewStore(afero.NewMemMapFs())
+	require.NoError(t, err)
+
+	// relative references resolve within the artifact
+	require.NoError(t, ExecuteUses(ctx, store, "oci://"+host+"/vai/tasks@"+digest+"?task=echo#tasks/echo.yaml", With{}, "file:test", false))
+	require.NoError(t, ExecuteUses(ctx, store, dst+"?task=echo#tasks/echo.yaml", With{}, "file:test", false))
+
+	// defaults to vai.yaml
+	require.NoError(t, ExecuteUses(ctx, store, "oci://"+host+"/vai/tasks:v1", With{}, "file:test", false))
+
+	write("escape.yaml", `
+default:
+  - uses: file:../outside.yaml
+`)
+	_, err = Publish(ctx, filepath.Join(dir, "escape.yaml"), dst)
+	require.EqualError(t, err, `escape.yaml references "file:../outside.yaml", which is outside of `+dir)
+
+	write("absolute.yaml", `
+default:
+  - uses: file:///tmp/vai.yaml
+`)
+	_, err = Publish(ctx, filepath.Join(dir, "absolute.yaml"), dst)
+	require.EqualError(t, err, `absolute.yaml references "file:///tmp/vai.yaml", only relative paths can be published`)
+
+	_, err = Publish(ctx, filepath.Join(dir, "dne.yaml"), dst)
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,149 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package vai
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/package-url/packageurl-go"
+)
+
+// Replacements redirect remote `uses` references to other locations, akin to `replace` in go.mod.
+//
+// A `pkg:` key without a version (pkg:github/acme/tasks) matches every version of that repository,
+// and a key with a version (pkg:github/acme/tasks@v1.0.0) only matches that version. The file within
+// the repository and the task are carried over to the replacement, which is usually a local
+// directory (file:../tasks) but can be another package.
+//
+// Any other key is matched as a prefix of the reference, which is swapped for the replacement.
+type Replacements map[string]string
+
+// ParseReplacements parses from=to pairs, as passed to --replace.
+func ParseReplacements(pairs []string) (Replacements, error) {
+	r := make(Replacements, len(pairs))
+	for _, pair := range pairs {
+		from, to, ok := strings.Cut(pair, "=")
+		if !ok || from == "" || to == "" {
+			return nil, fmt.Errorf("invalid replacement %q, must be of the form from=to", pair)
+		}
+		r[from] = to
+	}
+	return r, nil
+}
+
+type replacementsKey struct{}
+
+// WithReplacements returns a copy of ctx in which `uses` references are redirected according to r.
+func WithReplacements(ctx context.Context, r Replacements) context.Context {
+	return context.WithValue(ctx, replacementsKey{}, r)
+}
+
+func replacementsFromContext(ctx context.Context) Replacements {
+	r, _ := ctx.Value(replacementsKey{}).(Replacements)
+	return r
+}
+
+// Apply returns the replacement for location, and whether one matched.
+//
+// When more than one key matches, the longest wins.
+func (r Replacements) Apply(location string) (string, bool, error) {
+	keys := make([]string, 0, len(r))
+	for k := range r {
+		keys = append(keys, k)
+	}
+	// longest first, ties broken alphabetically so the result does not depend on map order
+	slices.SortFunc(keys, func(a, b string) int {
+		if len(a) != len(b) {
+			return len(b) - len(a)
+		}
+		return strings.Compare(a, b)
+	})
+
+	for _, from := range keys {
+		to := r[from]
+
+		if !strings.HasPrefix(from, "pkg:") {
+			if strings.HasPrefix(location, from) {
+				return to + strings.TrimPrefix(location, from), true, nil
+			}
+			continue
+		}
+
+		if !strings.HasPrefix(location, "pkg:") {
+			continue
+		}
+
+		pattern, err := packageurl.FromString(from)
+		if err != nil {
+			return "", false, fmt.Errorf("replace %q: %w", from, err)
+		}
+
+		pURL, err := packageurl.FromString(location)
+		if err != nil {
+			return "", false, err
+		}
+
+		if pattern.Type != pURL.Type || pattern.Namespace != pURL.Namespace || pattern.Name != pURL.Name {
+			continue
+		}
+		if pattern.Version != "" && pattern.Version != pURL.Version {
+			continue
+		}
+
+		next, err := replacePackage(pURL, to)
+		if err != nil {
+			return "", false, fmt.Errorf("replace %q: %w", from, err)
+		}
+		return next, true, nil
+	}
+
+	return location, false, nil
+}
+
+// replacePackage points the file and task of pURL at the replacement to.
+func replacePackage(pURL packageurl.PackageURL, to string) (string, error) {
+	task := pURL.Qualifiers.Map()["task"]
+
+	target, err := url.Parse(to)
+	if err != nil {
+		return "", err
+	}
+
+	switch target.Scheme {
+	case "file":
+		p := target.Opaque
+		if p == "" {
+			p = target.Path
+		}
+		next := &url.URL{Scheme: "file", Opaque: path.Join(p, pURL.Subpath)}
+		if task != "" {
+			next.RawQuery = url.Values{"task": []string{task}}.Encode()
+		}
+		return next.String(), nil
+	case "pkg":
+		next, err := packageurl.FromString(to)
+		if err != nil {
+			return "", err
+		}
+		if next.Version == "" {
+			next.Version = pURL.Version
+		}
+		if next.Subpath == "" {
+			next.Subpath = pURL.Subpath
+		}
+		if task != "" {
+			qualifiers := next.Qualifiers.Map()
+			qualifiers["task"] = task
+			next.Qualifiers = packageurl.QualifiersFromMap(qualifiers)
+		}
+		return next.String(), nil
+	default:
+		return "", fmt.Errorf("unsupported replacement scheme %q, must be file: or pkg:", target.Scheme)
+	}
+}
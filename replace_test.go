@@ -0,0 +1,108 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package vai
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplacements(t *testing.T) {
+	r := Replacements{
+		"pkg:github/acme/tasks":                  "file:../tasks",
+		"pkg:github/acme/tasks@v1.0.0":           "file:/opt/tasks-v1",
+		"pkg:github/acme/lib":                    "pkg:gitlab/fork/lib?base=https://git.internal",
+		"pkg:github/acme/pinned":                 "pkg:github/fork/pinned@dev#other.yaml",
+		"https://example.com/":                   "file:mirror/",
+		"https://example.com/special/":           "https://special.example.com/",
+		"pkg:github/acme/broken":                 "oci://example.com/broken",
+		"pkg:github/acme/tasks@v2.0.0?task=echo": "file:never",
+	}
+
+	testCases := []struct {
+		location    string
+		expected    string
+		replaced    bool
+		expectedErr string
+	}{
+		{
+			location: "pkg:github/acme/tasks@main?task=echo#dir/vai.yaml",
+			expected: "file:../tasks/dir/vai.yaml?task=echo",
+			replaced: true,
+		},
+		{
+			location: "pkg:github/acme/tasks@v1.0.0#vai.yaml",
+			expected: "file:/opt/tasks-v1/vai.yaml",
+			replaced: true,
+		},
+		{
+			location: "pkg:github/acme/lib@v1.2.0?task=build#vai.yaml",
+			expected: "pkg:gitlab/fork/lib@v1.2.0?base=https%3A%2F%2Fgit.internal&task=build#vai.yaml",
+			replaced: true,
+		},
+		{
+			location: "pkg:github/acme/pinned@v1.0.0#vai.yaml",
+			expected: "pkg:github/fork/pinned@dev#other.yaml",
+			replaced: true,
+		},
+		{
+			location: "https://example.com/a/vai.yaml?task=echo",
+			expected: "file:mirror/a/vai.yaml?task=echo",
+			replaced: true,
+		},
+		{
+			location: "https://example.com/special/vai.yaml",
+			expected: "https://special.example.com/vai.yaml",
+			replaced: true,
+		},
+		{
+			location: "pkg:github/other/tasks@main#vai.yaml",
+			expected: "pkg:github/other/tasks@main#vai.yaml",
+		},
+		{
+			location: "pkg:gitlab/acme/tasks@main#vai.yaml",
+			expected: "pkg:gitlab/acme/tasks@main#vai.yaml",
+		},
+		{
+			location: "file:vai.yaml",
+			expected: "file:vai.yaml",
+		},
+		{
+			location:    "pkg:github/acme/broken@main#vai.yaml",
+			expectedErr: `replace "pkg:github/acme/broken": unsupported replacement scheme "oci", must be file: or pkg:`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.location, func(t *testing.T) {
+			next, ok, err := r.Apply(tc.location)
+			if tc.expectedErr != "" {
+				require.EqualError(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.replaced, ok)
+			require.Equal(t, tc.expected, next)
+		})
+	}
+}
+
+func TestParseReplacements(t *testing.T) {
+	r, err := ParseReplacements([]string{"pkg:github/acme/tasks=file:../tasks", "https://example.com/=https://mirror.example.com/?a=b"})
+	require.NoError(t, err)
+	require.Equal(t, Replacements{
+		"pkg:github/acme/tasks": "file:../tasks",
+		"https://example.com/":  "https://mirror.example.com/?a=b",
+	}, r)
+
+	r, err = ParseReplacements(nil)
+	require.NoError(t, err)
+	require.Empty(t, r)
+
+	for _, pair := range []string{"pkg:github/acme/tasks", "=file:../tasks", "pkg:github/acme/tasks="} {
+		_, err = ParseReplacements([]string{pair})
+		require.EqualError(t, err, `invalid replacement "`+pair+`", must be of the form from=to`)
+	}
+}
@@ -0,0 +1,120 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package vai
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strings"
+
+	"github.com/package-url/packageurl-go"
+)
+
+// RewriteRule redirects every `uses` reference matching From to To, eg. to use an internal mirror.
+//
+// For `pkg:` rules, a trailing * in From captures the rest of the repository path (namespace and name),
+// which replaces the * in To. The version, file and task of the reference are kept, and qualifiers in To
+// (eg. base) are added to the reference:
+//
+//	from: pkg:github/*
+//	to: pkg:gitlab/mirror/*?base=https://git.internal
+//
+// rewrites pkg:github/acme/tasks@v1#vai.yaml to pkg:gitlab/mirror/acme/tasks@v1?base=https://git.internal#vai.yaml
+//
+// Any other rule is matched as a prefix of the reference, a trailing * is optional:
+//
+//	from: https://raw.githubusercontent.com/
+//	to: https://proxy.internal/raw/
+type RewriteRule struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Rewrites is an ordered list of rewrite rules, the first matching rule wins.
+type Rewrites []RewriteRule
+
+type rewritesKey struct{}
+
+// WithRewrites returns a copy of ctx in which `uses` references are rewritten according to r.
+func WithRewrites(ctx context.Context, r Rewrites) context.Context {
+	return context.WithValue(ctx, rewritesKey{}, r)
+}
+
+func rewritesFromContext(ctx context.Context) Rewrites {
+	r, _ := ctx.Value(rewritesKey{}).(Rewrites)
+	return r
+}
+
+// Apply returns location rewritten by the first matching rule, and whether one matched.
+func (r Rewrites) Apply(location string) (string, bool, error) {
+	for _, rule := range r {
+		next, ok, err := rule.apply(location)
+		if err != nil {
+			return "", false, fmt.Errorf("rewrite %q: %w", rule.From, err)
+		}
+		if ok {
+			return next, true, nil
+		}
+	}
+	return location, false, nil
+}
+
+func (rule RewriteRule) apply(location string) (string, bool, error) {
+	from := strings.TrimSuffix(rule.From, "*")
+	wildcard := from != rule.From
+
+	if !strings.HasPrefix(rule.From, "pkg:") {
+		if !strings.HasPrefix(location, from) {
+			return "", false, nil
+		}
+		return strings.TrimSuffix(rule.To, "*") + strings.TrimPrefix(location, from), true, nil
+	}
+
+	if !strings.HasPrefix(location, "pkg:") {
+		return "", false, nil
+	}
+
+	pURL, err := packageurl.FromString(location)
+	if err != nil {
+		return "", false, err
+	}
+
+	// match against the repository path only, so versions and qualifiers do not get in the way
+	repo := pURL.Type + "/" + pURL.Name
+	if pURL.Namespace != "" {
+		repo = pURL.Type + "/" + pURL.Namespace + "/" + pURL.Name
+	}
+	pattern := strings.TrimPrefix(from, "pkg:")
+
+	var captured string
+	switch {
+	case wildcard && strings.HasPrefix(repo, pattern):
+		captured = strings.TrimPrefix(repo, pattern)
+	case !wildcard && repo == pattern:
+	default:
+		return "", false, nil
+	}
+
+	to, query, _ := strings.Cut(rule.To, "?")
+	target := "pkg:" + strings.Replace(strings.TrimPrefix(to, "pkg:"), "*", captured, 1)
+	if query != "" {
+		target += "?" + query
+	}
+
+	next, err := packageurl.FromString(target)
+	if err != nil {
+		return "", false, err
+	}
+
+	qualifiers := pURL.Qualifiers.Map()
+	maps.Copy(qualifiers, next.Qualifiers.Map())
+
+	pURL.Type = next.Type
+	pURL.Namespace = next.Namespace
+	pURL.Name = next.Name
+	pURL.Qualifiers = packageurl.QualifiersFromMap(qualifiers)
+
+	return pURL.String(), true, nil
+}
@@ -0,0 +1,86 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package vai
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRewrites(t *testing.T) {
+	r := Rewrites{
+		{From: "pkg:github/acme/special", To: "pkg:gitea/acme/special?base=https://gitea.internal"},
+		{From: "pkg:github/*", To: "pkg:gitlab/mirror/*?base=https://git.internal"},
+		{From: "pkg:gitlab/upstream/*", To: "pkg:gitlab/*"},
+		{From: "https://raw.githubusercontent.com/", To: "https://proxy.internal/raw/"},
+		{From: "oci://ghcr.io/*", To: "oci://registry.internal/ghcr/*"},
+	}
+
+	testCases := []struct {
+		location string
+		expected string
+		matched  bool
+	}{
+		{
+			location: "pkg:github/acme/tasks@v1.0.0?task=echo#dir/vai.yaml",
+			expected: "pkg:gitlab/mirror/acme/tasks@v1.0.0?base=https%3A%2F%2Fgit.internal&task=echo#dir/vai.yaml",
+			matched:  true,
+		},
+		{
+			location: "pkg:github/acme/special@main#vai.yaml",
+			expected: "pkg:gitea/acme/special@main?base=https%3A%2F%2Fgitea.internal#vai.yaml",
+			matched:  true,
+		},
+		{
+			location: "pkg:github/acme/special-other@main#vai.yaml",
+			expected: "pkg:gitlab/mirror/acme/special-other@main?base=https%3A%2F%2Fgit.internal#vai.yaml",
+			matched:  true,
+		},
+		{
+			location: "pkg:gitlab/upstream/group/project@v2#vai.yaml",
+			expected: "pkg:gitlab/group/project@v2#vai.yaml",
+			matched:  true,
+		},
+		{
+			location: "https://raw.githubusercontent.com/acme/tasks/main/vai.yaml?task=echo",
+			expected: "https://proxy.internal/raw/acme/tasks/main/vai.yaml?task=echo",
+			matched:  true,
+		},
+		{
+			location: "oci://ghcr.io/acme/tasks:v1#vai.yaml",
+			expected: "oci://registry.internal/ghcr/acme/tasks:v1#vai.yaml",
+			matched:  true,
+		},
+		{
+			location: "pkg:gitlab/acme/tasks@main#vai.yaml",
+			expected: "pkg:gitlab/acme/tasks@main#vai.yaml",
+		},
+		{
+			location: "https://example.com/vai.yaml",
+			expected: "https://example.com/vai.yaml",
+		},
+		{
+			location: "file:vai.yaml",
+			expected: "file:vai.yaml",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.location, func(t *testing.T) {
+			next, ok, err := r.Apply(tc.location)
+			require.NoError(t, err)
+			require.Equal(t, tc.matched, ok)
+			require.Equal(t, tc.expected, next)
+		})
+	}
+
+	next, ok, err := Rewrites(nil).Apply("pkg:github/acme/tasks@main#vai.yaml")
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.Equal(t, "pkg:github/acme/tasks@main#vai.yaml", next)
+
+	_, _, err = Rewrites{{From: "pkg:github/*", To: "pkg:"}}.Apply("pkg:github/acme/tasks@main#vai.yaml")
+	require.EqualError(t, err, `rewrite "pkg:github/*": purl is missing type or name`)
+}
@@ -5,6 +5,7 @@
 package vai
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -28,9 +29,22 @@ func Run(ctx context.Context, store *uses.Store, wf Workflow, taskName string, o
 
 	task, ok := wf.Find(taskName)
 	if !ok {
+		// tasks of included workflows are called like remote tasks, eg. `go:build`
+		if ref, sub, ok := wf.include(taskName); ok {
+			u, err := withTask(ref, sub)
+			if err != nil {
+				return err
+			}
+			return ExecuteUses(ctx, store, u, outer, origin, dry)
+		}
 		return fmt.Errorf("task %q not found", taskName)
 	}
 
+	ctx, err := enter(ctx, origin, taskName)
+	if err != nil {
+		return err
+	}
+
 	outputs := make(CommandOutputs)
 
 	for _, step := range task {
@@ -40,7 +54,7 @@ func Run(ctx context.Context, store *uses.Store, wf Workflow, taskName string, o
 		}
 
 		if step.Uses != "" {
-			if _, ok := wf.Find(step.Uses); ok {
+			if wf.local(step.Uses) {
 				if err := Run(ctx, store, wf, step.Uses, templated, origin, dry); err != nil {
 					return err
 				}
@@ -89,59 +103,23 @@ func Run(ctx context.Context, store *uses.Store, wf Workflow, taskName string, o
 				continue
 			}
 
-			outFile, err := os.CreateTemp("", "vai-output-*")
+			// TODO: handle other shells
+			out, err := execute(ctx, templated, step.ID != "", "sh", "-e", "-c", step.Run)
 			if err != nil {
 				return err
 			}
-			defer os.Remove(outFile.Name())
-			defer outFile.Close()
-
-			env := os.Environ()
-			for k, v := range templated {
-				var val string
-				switch v := v.(type) {
-				case string:
-					val = v
-				case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
-					val = fmt.Sprintf("%d", v)
-				case bool:
-					val = fmt.Sprintf("%t", v)
-				default:
-					// JSON marshal all other types
-					b, err := json.Marshal(v)
-					if err != nil {
-						return err
-					}
-					val = string(b)
-				}
-
-				env = append(env, fmt.Sprintf("%s=%s", toEnvVar(k), val))
+			if step.ID != "" && len(out) > 0 {
+				outputs[step.ID] = out
 			}
-			env = append(env, fmt.Sprintf("VAI_OUTPUT=%s", outFile.Name()))
-			// TODO: handle other shells
-			cmd := exec.CommandContext(ctx, "sh", "-e", "-c", step.Run)
-			cmd.Env = env
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-			cmd.Stdin = os.Stdin
+		}
 
-			if err := cmd.Run(); err != nil {
+		if step.Script != "" {
+			out, err := executeScript(ctx, store, step.Script, templated, origin, dry, step.ID != "")
+			if err != nil {
 				return err
 			}
-
-			if step.ID != "" {
-				out, err := ParseOutput(outFile)
-				if err != nil {
-					return err
-				}
-				if len(out) == 0 {
-					continue
-				}
-				// TODO: conflicted about whether to save the contents of the file or just the file path
-				outputs[step.ID] = make(map[string]any)
-				for k, v := range out {
-					outputs[step.ID][k] = v
-				}
+			if step.ID != "" && len(out) > 0 {
+				outputs[step.ID] = out
 			}
 		}
 	}
@@ -152,3 +130,117 @@ func Run(ctx context.Context, store *uses.Store, wf Workflow, taskName string, o
 func toEnvVar(s string) string {
 	return strings.ToUpper(strings.ReplaceAll(s, "-", "_"))
 }
+
+// ExecuteScript fetches the script file at u, relative to the workflow at prev, and runs it.
+//
+// Scripts starting with a shebang are executed directly, all others are run with `sh -e`.
+func ExecuteScript(ctx context.Context, store *uses.Store, u string, with With, prev string, dry bool) (map[string]any, error) {
+	return executeScript(ctx, store, u, with, prev, dry, true)
+}
+
+// executeScript is ExecuteScript, only parsing the outputs of the script if parse is set.
+func executeScript(ctx context.Context, store *uses.Store, u string, with With, prev string, dry, parse bool) (map[string]any, error) {
+	ref, err := locate(ctx, store, u, prev)
+	if err != nil {
+		return nil, err
+	}
+
+	b, refs, err := read(ctx, store, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	printScript(ctx, "$", ref.location)
+	if dry {
+		return nil, nil
+	}
+
+	if err := review(ctx, store, ref, refs[ref.location]); err != nil {
+		return nil, err
+	}
+
+	f, err := os.CreateTemp("", "vai-script-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(b, []byte("#!")) {
+		if err := os.Chmod(f.Name(), 0700); err != nil {
+			return nil, err
+		}
+		return execute(ctx, with, parse, f.Name())
+	}
+
+	return execute(ctx, with, parse, "sh", "-e", f.Name())
+}
+
+// execute runs a command with the inputs in with as environment variables,
+// returning any outputs it wrote to $VAI_OUTPUT if parse is set.
+//
+// Only the outputs of steps with an id can be referenced, so other steps are free to write anything to $VAI_OUTPUT.
+func execute(ctx context.Context, with With, parse bool, name string, args ...string) (map[string]any, error) {
+	outFile, err := os.CreateTemp("", "vai-output-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(outFile.Name())
+	defer outFile.Close()
+
+	env := os.Environ()
+	for k, v := range with {
+		var val string
+		switch v := v.(type) {
+		case string:
+			val = v
+		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+			val = fmt.Sprintf("%d", v)
+		case bool:
+			val = fmt.Sprintf("%t", v)
+		default:
+			// JSON marshal all other types
+			b, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			val = string(b)
+		}
+
+		env = append(env, fmt.Sprintf("%s=%s", toEnvVar(k), val))
+	}
+	env = append(env, fmt.Sprintf("VAI_OUTPUT=%s", outFile.Name()))
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = env
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Stdin = os.Stdin
+
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+
+	if !parse {
+		return nil, nil
+	}
+
+	out, err := ParseOutput(outFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// TODO: conflicted about whether to save the contents of the file or just the file path
+	outputs := make(map[string]any, len(out))
+	for k, v := range out {
+		outputs[k] = v
+	}
+	return outputs, nil
+}
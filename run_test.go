@@ -5,6 +5,8 @@ package vai
 
 import (
 	"context"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
@@ -81,6 +83,57 @@ echo "bool: $B, int: $I"
 	})
 }
 
+func TestExecuteScript(t *testing.T) {
+	files := map[string]string{
+		"/tasks/vai.yaml":         "default:\n  - script: file:scripts/greet.sh\n    id: greet\n    with:\n      name: input\n",
+		"/tasks/scripts/greet.sh": "#!/bin/sh\necho \"greeting=hello $NAME\" >> \"$VAI_OUTPUT\"\n",
+		"/tasks/scripts/plain.sh": "echo \"plain=$SHELL_VAR\" >> \"$VAI_OUTPUT\"\n",
+	}
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		b, ok := files[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(b))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	ctx := context.Background()
+	store, err := uses.NewStore(afero.NewMemMapFs())
+	require.NoError(t, err)
+
+	origin := server.URL + "/tasks/vai.yaml"
+
+	// relative to the remote workflow, run with a shebang
+	out, err := ExecuteScript(ctx, store, "file:scripts/greet.sh", With{"name": "world"}, origin, false)
+	require.NoError(t, err)
+	require.Equal(t, map[string]any{"greeting": "hello world"}, out)
+
+	// run with sh
+	out, err = ExecuteScript(ctx, store, "file:scripts/plain.sh", With{"shell-var": 1}, origin, false)
+	require.NoError(t, err)
+	require.Equal(t, map[string]any{"plain": "1"}, out)
+
+	// dry runs fetch the script, but do not run it
+	out, err = ExecuteScript(ctx, store, "file:scripts/greet.sh", With{}, origin, true)
+	require.NoError(t, err)
+	require.Nil(t, out)
+
+	_, err = ExecuteScript(ctx, store, "file:scripts/dne.sh", With{}, origin, true)
+	require.Error(t, err)
+
+	// called through a remote workflow
+	require.NoError(t, ExecuteUses(ctx, store, origin, With{"name": "world"}, "file:vai.yaml", false))
+
+	// scripts are subject to the policy
+	ctx = WithPolicy(ctx, Policy{Schemes: Patterns{Deny: []string{"http"}}})
+	_, err = ExecuteScript(ctx, store, "file:scripts/greet.sh", With{}, origin, false)
+	require.ErrorContains(t, err, "is not allowed by policy")
+}
+
 func TestToEnvVar(t *testing.T) {
 	testCases := []struct {
 		name     string
@@ -0,0 +1,77 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package vai
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// DefaultMaxDepth is the default maximum depth of nested task calls.
+const DefaultMaxDepth = 64
+
+// frame is a task being run, and the origin of the workflow it is in.
+type frame struct {
+	origin string
+	task   string
+}
+
+func (f frame) String() string {
+	return fmt.Sprintf("%s (%s)", f.task, f.origin)
+}
+
+// callStack is the chain of tasks that led to the current task, outermost first.
+type callStack []frame
+
+func (s callStack) String() string {
+	calls := make([]string, len(s))
+	for i, f := range s {
+		calls[i] = f.String()
+	}
+	return strings.Join(calls, " -> ")
+}
+
+type callStackKey struct{}
+
+type maxDepthKey struct{}
+
+// WithMaxDepth returns a copy of ctx in which tasks may only be nested n calls deep.
+func WithMaxDepth(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, maxDepthKey{}, n)
+}
+
+func maxDepthFromContext(ctx context.Context) int {
+	if n, ok := ctx.Value(maxDepthKey{}).(int); ok {
+		return n
+	}
+	return DefaultMaxDepth
+}
+
+// enter pushes a task onto the call stack in ctx.
+//
+// It returns an error with the full call chain if the task is already being run,
+// or if the maximum depth would be exceeded.
+func enter(ctx context.Context, origin, task string) (context.Context, error) {
+	// every task in a file shares its origin, regardless of which task was called to get there
+	origin, err := withoutTask(origin)
+	if err != nil {
+		return nil, err
+	}
+
+	next := frame{origin: origin, task: task}
+
+	stack, _ := ctx.Value(callStackKey{}).(callStack)
+
+	if i := slices.Index(stack, next); i >= 0 {
+		return nil, fmt.Errorf("cycle detected: %s", append(slices.Clone(stack[i:]), next))
+	}
+
+	if limit := maxDepthFromContext(ctx); len(stack) >= limit {
+		return nil, fmt.Errorf("maximum call depth of %d exceeded: %s", limit, append(slices.Clone(stack), next))
+	}
+
+	return context.WithValue(ctx, callStackKey{}, append(slices.Clip(stack), next)), nil
+}
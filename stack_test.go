@@ -0,0 +1,58 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package vai
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/noxsios/vai/uses"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCallStack(t *testing.T) {
+	store, err := uses.NewStore(afero.NewMemMapFs())
+	require.NoError(t, err)
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a.yaml":
+			_, _ = w.Write([]byte("default:\n  - uses: file:b.yaml?task=b\n"))
+		case "/b.yaml":
+			_, _ = w.Write([]byte("b:\n  - uses: file:a.yaml\n"))
+		case "/self.yaml":
+			// calling the same file with a different task query still refers to the same workflow
+			_, _ = w.Write([]byte("default:\n  - uses: file:self.yaml?task=default\n"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	ctx := context.Background()
+
+	err = ExecuteUses(ctx, store, server.URL+"/a.yaml", With{}, "file:vai.yaml", false)
+	require.EqualError(t, err, fmt.Sprintf("cycle detected: default (%[1]s/a.yaml) -> b (%[1]s/b.yaml) -> default (%[1]s/a.yaml)", server.URL))
+
+	err = ExecuteUses(ctx, store, server.URL+"/self.yaml", With{}, "file:vai.yaml", false)
+	require.EqualError(t, err, fmt.Sprintf("cycle detected: default (%[1]s/self.yaml) -> default (%[1]s/self.yaml)", server.URL))
+
+	// a deep but finite chain of calls
+	wf := Workflow{}
+	for i := range 10 {
+		wf[fmt.Sprintf("t%d", i)] = Task{Step{Uses: fmt.Sprintf("t%d", i+1)}}
+	}
+	wf["t10"] = Task{Step{Run: "true"}}
+
+	require.NoError(t, Run(ctx, store, wf, "t0", With{}, "file:vai.yaml", false))
+
+	ctx = WithMaxDepth(ctx, 5)
+	err = Run(ctx, store, wf, "t0", With{}, "file:vai.yaml", false)
+	require.EqualError(t, err, "maximum call depth of 5 exceeded: t0 (file:vai.yaml) -> t1 (file:vai.yaml) -> t2 (file:vai.yaml) -> t3 (file:vai.yaml) -> t4 (file:vai.yaml) -> t5 (file:vai.yaml)")
+}
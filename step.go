@@ -9,13 +9,15 @@ import (
 
 // Step is a single step in a task
 //
-// While a step can have any combination of `run`, `eval`, and `uses` fields, only one of them should be set
+// While a step can have any combination of `run`, `script`, `eval`, and `uses` fields, only one of them should be set
 // at a time.
 //
 // This is enforced by JSON schema validation.
 type Step struct {
 	// Run is the command/script to run
 	Run string `json:"run,omitempty"`
+	// Script is a reference to a script file to run, resolved relative to the workflow like `uses`
+	Script string `json:"script,omitempty"`
 	// Eval is an expression to evaluate with tengo
 	Eval string `json:"eval,omitempty"`
 	// Uses is a reference to another task
@@ -39,6 +41,10 @@ func (Step) JSONSchemaExtend(schema *jsonschema.Schema) {
 		Type:        "string",
 		Description: "Command/script to run",
 	})
+	props.Set("script", &jsonschema.Schema{
+		Type:        "string",
+		Description: "Location of a script file to run, relative to the workflow",
+	})
 	props.Set("uses", &jsonschema.Schema{
 		Type:        "string",
 		Description: "Location of a remote task to call conforming to the purl spec",
@@ -88,6 +94,7 @@ func (Step) JSONSchemaExtend(schema *jsonschema.Schema) {
 	runProps.Set("run", &jsonschema.Schema{
 		Type: "string",
 	})
+	runProps.Set("script", not)
 	runProps.Set("uses", not)
 	runProps.Set("eval", not)
 	oneOfRun := &jsonschema.Schema{
@@ -97,6 +104,7 @@ func (Step) JSONSchemaExtend(schema *jsonschema.Schema) {
 
 	usesProps := jsonschema.NewProperties()
 	usesProps.Set("run", not)
+	usesProps.Set("script", not)
 	usesProps.Set("eval", not)
 	usesProps.Set("uses", &jsonschema.Schema{
 		Type: "string",
@@ -108,6 +116,7 @@ func (Step) JSONSchemaExtend(schema *jsonschema.Schema) {
 
 	evalProps := jsonschema.NewProperties()
 	evalProps.Set("run", not)
+	evalProps.Set("script", not)
 	evalProps.Set("uses", not)
 	evalProps.Set("eval", &jsonschema.Schema{
 		Type: "string",
@@ -117,10 +126,23 @@ func (Step) JSONSchemaExtend(schema *jsonschema.Schema) {
 		Properties: evalProps,
 	}
 
+	scriptProps := jsonschema.NewProperties()
+	scriptProps.Set("run", not)
+	scriptProps.Set("uses", not)
+	scriptProps.Set("eval", not)
+	scriptProps.Set("script", &jsonschema.Schema{
+		Type: "string",
+	})
+	oneOfScript := &jsonschema.Schema{
+		Required:   []string{"script"},
+		Properties: scriptProps,
+	}
+
 	schema.Properties = props
 	schema.OneOf = []*jsonschema.Schema{
 		oneOfRun,
 		oneOfUses,
 		oneOfEval,
+		oneOfScript,
 	}
 }
@@ -0,0 +1,122 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package vai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/noxsios/vai/uses"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// ConfirmFunc is asked whether to run a remote workflow whose content changed since it was last run.
+//
+// diff is a unified diff of the change, or empty if the previous content is no longer cached.
+type ConfirmFunc func(location, diff string) (bool, error)
+
+type confirmKey struct{}
+
+// WithConfirm returns a copy of ctx in which remote workflows are trusted on first use,
+// and confirm must accept any later change to their content before it is run.
+func WithConfirm(ctx context.Context, confirm ConfirmFunc) context.Context {
+	return context.WithValue(ctx, confirmKey{}, confirm)
+}
+
+func confirmFromContext(ctx context.Context) (ConfirmFunc, bool) {
+	confirm, ok := ctx.Value(confirmKey{}).(ConfirmFunc)
+	return confirm, ok
+}
+
+// review compares the content of the remote workflow at ref with the content last run from the same reference.
+//
+// Content seen for the first time is trusted and recorded. Changed content is only recorded, and run,
+// once the ConfirmFunc in ctx accepts the diff. Local files and contexts without a ConfirmFunc are not reviewed.
+func review(ctx context.Context, store *uses.Store, ref reference, desc uses.Descriptor) error {
+	logger := log.FromContext(ctx)
+
+	confirm, ok := confirmFromContext(ctx)
+	if !ok || strings.HasPrefix(ref.location, "file:") {
+		return nil
+	}
+
+	// every task in a file is trusted together, under the reference as written, so a range resolving to a new tag,
+	// or a new replacement or mirror, is reviewed as a change
+	location := ref.location
+	if ref.requested != "" {
+		location = ref.requested
+	}
+
+	key, err := withoutTask(location)
+	if err != nil {
+		return err
+	}
+
+	prev, ok, err := store.Trusted(key)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		logger.Debug("trusting on first use", "uses", key, "digest", desc.Hex)
+		return store.Trust(key, desc)
+	}
+
+	if prev == desc {
+		return nil
+	}
+
+	diff, err := diffContent(store, key, prev, desc)
+	if err != nil {
+		return err
+	}
+
+	accepted, err := confirm(key, diff)
+	if err != nil {
+		return err
+	}
+	if !accepted {
+		return fmt.Errorf("%s changed since it was last run and the change was not trusted", key)
+	}
+
+	return store.Trust(key, desc)
+}
+
+// diffContent returns a unified diff between two stored workflows, or an empty string if prev is no longer cached.
+func diffContent(store *uses.Store, location string, prev, next uses.Descriptor) (string, error) {
+	read := func(desc uses.Descriptor) (string, error) {
+		rc, err := store.Fetch(desc)
+		if err != nil {
+			return "", err
+		}
+		defer rc.Close()
+		b, err := io.ReadAll(rc)
+		return string(b), err
+	}
+
+	before, err := read(prev)
+	if errors.Is(err, uses.ErrDescriptorNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	after, err := read(next)
+	if err != nil {
+		return "", err
+	}
+
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(before),
+		B:        difflib.SplitLines(after),
+		FromFile: location + " (sha256:" + prev.Hex[:min(12, len(prev.Hex))] + ")",
+		ToFile:   location + " (sha256:" + next.Hex[:min(12, len(next.Hex))] + ")",
+		Context:  3,
+	})
+}
@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package vai
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/noxsios/vai/uses"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReview(t *testing.T) {
+	content := "default:\n  - run: echo 'v1'\n"
+
+	handler := func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(content))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	fs := afero.NewMemMapFs()
+	store, err := uses.NewStore(fs)
+	require.NoError(t, err)
+
+	var asked []string
+	accept := false
+	ctx := WithConfirm(context.Background(), func(location, diff string) (bool, error) {
+		asked = append(asked, location, diff)
+		return accept, nil
+	})
+
+	location := server.URL + "/vai.yaml"
+
+	// first use is trusted
+	require.NoError(t, ExecuteUses(ctx, store, location+"?task=default", With{}, "file:vai.yaml", false))
+	require.Empty(t, asked)
+
+	// unchanged content is not reviewed
+	require.NoError(t, ExecuteUses(ctx, store, location, With{}, "file:vai.yaml", false))
+	require.Empty(t, asked)
+
+	content = "default:\n  - run: echo 'v2'\n"
+
+	// dry runs do not execute anything, so are not reviewed
+	require.NoError(t, ExecuteUses(ctx, store, location, With{}, "file:vai.yaml", true))
+	require.Empty(t, asked)
+
+	err = ExecuteUses(ctx, store, location+"?task=default", With{}, "file:vai.yaml", false)
+	require.EqualError(t, err, location+" changed since it was last run and the change was not trusted")
+	require.Len(t, asked, 2)
+	require.Equal(t, location, asked[0])
+	require.Contains(t, asked[1], "-  - run: echo 'v1'\n+  - run: echo 'v2'\n")
+
+	// still not trusted, so asked again
+	require.Error(t, ExecuteUses(ctx, store, location, With{}, "file:vai.yaml", false))
+	require.Len(t, asked, 4)
+
+	accept = true
+	require.NoError(t, ExecuteUses(ctx, store, location, With{}, "file:vai.yaml", false))
+	require.Len(t, asked, 6)
+
+	// the accepted change is remembered
+	require.NoError(t, ExecuteUses(ctx, store, location, With{}, "file:vai.yaml", false))
+	require.Len(t, asked, 6)
+
+	// errors from the prompt are returned as is
+	content = "default:\n  - run: echo 'v3'\n"
+	ctx = WithConfirm(context.Background(), func(_, _ string) (bool, error) {
+		return false, fmt.Errorf("no terminal")
+	})
+	require.EqualError(t, ExecuteUses(ctx, store, location, With{}, "file:vai.yaml", false), "no terminal")
+
+	// without a ConfirmFunc nothing is reviewed
+	require.NoError(t, ExecuteUses(context.Background(), store, location, With{}, "file:vai.yaml", false))
+
+	// redirecting a reference to other content is reviewed as a change to the reference as written
+	accept = true
+	ctx = WithConfirm(context.Background(), func(location, diff string) (bool, error) {
+		asked = append(asked, location, diff)
+		return accept, nil
+	})
+	require.NoError(t, ExecuteUses(ctx, store, location, With{}, "file:vai.yaml", false))
+	asked = nil
+	accept = false
+
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("default:\n  - run: echo 'mirrored'\n"))
+	}))
+	defer mirror.Close()
+
+	redirected := WithReplacements(ctx, Replacements{server.URL: mirror.URL})
+	err = ExecuteUses(redirected, store, location, With{}, "file:vai.yaml", false)
+	require.EqualError(t, err, location+" changed since it was last run and the change was not trusted")
+	require.Equal(t, location, asked[0])
+	require.Contains(t, asked[1], "+  - run: echo 'mirrored'\n")
+}
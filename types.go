@@ -25,17 +25,25 @@ type Task []Step
 type Workflow map[string]Task
 
 // Find returns a task by name
+//
+// Included workflows are not tasks, and are never found.
 func (wf Workflow) Find(call string) (Task, bool) {
+	if IncludePattern.MatchString(call) {
+		return nil, false
+	}
 	task, ok := wf[call]
 	return task, ok
 }
 
 // OrderedTaskNames returns a list of task names in alphabetical order
 //
-// The default task is always first
+// The default task is always first, included workflows are not listed
 func (wf Workflow) OrderedTaskNames() []string {
 	names := make([]string, 0, len(wf))
 	for k := range wf {
+		if IncludePattern.MatchString(k) {
+			continue
+		}
 		names = append(names, k)
 	}
 	slices.SortStableFunc(names, func(a, b string) int {
@@ -61,6 +69,10 @@ func WorkFlowSchema() *jsonschema.Schema {
 			Ref:         "#/$defs/Task",
 			Description: "Name of the task",
 		},
+		IncludePattern.String(): {
+			Type:        "string",
+			Description: "Location of a workflow whose tasks are callable as <namespace>:<task>",
+		},
 	}
 
 	schema.ID = "https://raw.githubusercontent.com/Noxsios/vai/main/vai.schema.json"
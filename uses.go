@@ -4,11 +4,14 @@
 package vai
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"fmt"
 	"io"
 	"net/url"
 	"path/filepath"
+	"strings"
 
 	"github.com/charmbracelet/log"
 	"github.com/noxsios/vai/uses"
@@ -18,44 +21,68 @@ import (
 // CacheEnvVar is the environment variable for the cache directory.
 const CacheEnvVar = "VAI_CACHE"
 
-// ExecuteUses runs a task from a remote workflow source.
-func ExecuteUses(ctx context.Context, store *uses.Store, u string, with With, prev string, dry bool) error {
+// reference is a `uses` reference resolved against the origin of the workflow that made the call.
+type reference struct {
+	// uri is the reference as written in the calling workflow
+	uri *url.URL
+	// previous is the origin of the calling workflow
+	previous *url.URL
+	// location is the absolute location of the called workflow, passed to the fetcher
+	location string
+	// origin is the origin of the called workflow, used to resolve its own relative references
+	origin string
+	// task is the name of the task to call
+	task string
+	// requested is the location as written, before any replacements, rewrites or version resolution,
+	// if they changed it
+	requested string
+}
+
+// resolve turns a `uses` reference into an absolute reference, relative to the origin of the calling workflow.
+func resolve(ctx context.Context, u, prev string) (reference, error) {
 	logger := log.FromContext(ctx)
-	logger.Debug("using", "task", u)
 
 	uri, err := url.Parse(u)
 	if err != nil {
-		return err
+		return reference{}, err
 	}
 
 	if uri.Scheme == "" {
-		return fmt.Errorf("must contain a scheme: %q", u)
+		return reference{}, fmt.Errorf("must contain a scheme: %q", u)
 	}
 
 	previous, err := url.Parse(prev)
 	if err != nil {
-		return err
+		return reference{}, err
 	}
 
 	if previous.Scheme == "" {
-		return fmt.Errorf("must contain a scheme: %q", prev)
+		return reference{}, fmt.Errorf("must contain a scheme: %q", prev)
 	}
 
 	var next *url.URL
 
 	if uri.Scheme == "file" {
-		switch previous.Scheme {
-		case "http", "https":
+		switch {
+		case previous.Scheme == "oci" || strings.HasPrefix(previous.Scheme, "git+") || uses.IsArchive(previous):
+			// turn relative paths into absolute references within the artifact, repository or archive
+			next = previous
+			next.Fragment = filepath.Join(filepath.Dir(previous.Fragment), uri.Opaque)
+			if next.Fragment == "." {
+				next.Fragment = DefaultFileName
+			}
+		case previous.Scheme == "http" || previous.Scheme == "https":
 			// turn relative paths into absolute references
 			next = previous
 			next.Path = filepath.Join(filepath.Dir(previous.Path), uri.Opaque)
 			if next.Path == "." {
 				next.Path = DefaultFileName
 			}
-		case "pkg":
+			next.Fragment = uri.Fragment
+		case previous.Scheme == "pkg":
 			pURL, err := packageurl.FromString(prev)
 			if err != nil {
-				return err
+				return reference{}, err
 			}
 			// turn relative paths into absolute references
 			pURL.Subpath = filepath.Join(filepath.Dir(pURL.Subpath), uri.Opaque)
@@ -63,18 +90,33 @@ func ExecuteUses(ctx context.Context, store *uses.Store, u string, with With, pr
 				pURL.Subpath = DefaultFileName
 			}
 			next, _ = url.Parse(pURL.String())
-		default:
-			dir := filepath.Dir(previous.Opaque)
+		case previous.Scheme == "file":
+			prevPath := previous.Opaque
+			if prevPath == "" {
+				// absolute paths (file:/abs/vai.yaml) are not opaque
+				prevPath = previous.Path
+			}
+			dir := filepath.Dir(prevPath)
 			if dir != "." {
 				next = &url.URL{
 					Scheme:   uri.Scheme,
 					Opaque:   filepath.Join(dir, uri.Opaque),
 					RawQuery: uri.RawQuery,
+					Fragment: uri.Fragment,
 				}
 				if next.Opaque == "." {
 					next.Opaque = DefaultFileName
 				}
 			}
+		default:
+			// schemes registered by library users, turn relative paths into absolute references
+			// within the fragment if the calling workflow has one, otherwise within its path
+			next = previous
+			if previous.Fragment != "" {
+				next.Fragment = filepath.Join(filepath.Dir(previous.Fragment), uri.Opaque)
+			} else {
+				next.Path = filepath.Join(filepath.Dir(previous.Path), uri.Opaque)
+			}
 		}
 
 		if next != nil {
@@ -87,6 +129,11 @@ func ExecuteUses(ctx context.Context, store *uses.Store, u string, with With, pr
 		next, _ = url.Parse(u)
 	}
 
+	if (uri.Scheme == "oci" || strings.HasPrefix(uri.Scheme, "git+") || uses.IsArchive(next)) && next.Fragment == "" {
+		next.Fragment = DefaultFileName
+		u = next.String()
+	}
+
 	if uri.Scheme == "pkg" {
 		// dogsledding the error here since we know it's a package URL
 		pURL, _ := packageurl.FromString(u)
@@ -99,62 +146,399 @@ func ExecuteUses(ctx context.Context, store *uses.Store, u string, with With, pr
 		u = pURL.String()
 	}
 
-	fetcher, err := uses.SelectFetcher(uri, previous)
+	return reference{
+		uri:      uri,
+		previous: previous,
+		location: u,
+		origin:   next.String(),
+		task:     uri.Query().Get("task"),
+	}, nil
+}
+
+// selectFetcher returns the fetcher for ref, or a fetcher backed by the ref index if one is in ctx.
+//
+// References left out of a plan are fetched when they are reached, a bundle is run without network access.
+func selectFetcher(ctx context.Context, store *uses.Store, ref reference) (uses.Fetcher, error) {
+	refs, ok := refIndexFromContext(ctx)
+	if !ok {
+		return uses.SelectFetcher(ref.uri, ref.previous)
+	}
+
+	var fallback uses.Fetcher
+	if plannedFromContext(ctx) {
+		var err error
+		fallback, err = uses.SelectFetcher(ref.uri, ref.previous)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return uses.NewRefFetcher(store, refs, fallback), nil
+}
+
+type updateVersionsKey struct{}
+
+// WithUpdateVersions returns a copy of ctx in which version ranges are resolved against the repository's tags again,
+// replacing the tags recorded in the store.
+func WithUpdateVersions(ctx context.Context) context.Context {
+	return context.WithValue(ctx, updateVersionsKey{}, true)
+}
+
+func updateVersionsFromContext(ctx context.Context) bool {
+	update, _ := ctx.Value(updateVersionsKey{}).(bool)
+	return update
+}
+
+// resolveVersion pins a `pkg:` reference whose version is a semver range (eg. ^1.4)
+// to the highest matching tag of the repository.
+//
+// The resolution is recorded in the store and reused by later runs, so a range only moves to a newer tag
+// when it is resolved again with WithUpdateVersions.
+//
+// The resolved tag replaces the range in both the location and origin,
+// so relative references within the called workflow use the same tag.
+func resolveVersion(ctx context.Context, store *uses.Store, ref reference) (reference, error) {
+	logger := log.FromContext(ctx)
+
+	if !strings.HasPrefix(ref.location, "pkg:") {
+		return ref, nil
+	}
+
+	pURL, err := packageurl.FromString(ref.location)
 	if err != nil {
-		return err
+		return reference{}, err
 	}
 
-	logger.Debug("chosen", "fetcher", fmt.Sprintf("%T", fetcher))
+	if !uses.IsVersionRange(pURL.Version) {
+		return ref, nil
+	}
+
+	// every file and task within the repository shares the resolution of a range
+	key, err := withoutTask(ref.location)
+	if err != nil {
+		return reference{}, err
+	}
+	repo, err := packageurl.FromString(key)
+	if err != nil {
+		return reference{}, err
+	}
+	repo.Subpath = ""
+	key = repo.String()
+
+	tag, ok, err := store.Resolved(key)
+	if err != nil {
+		return reference{}, err
+	}
+
+	if !ok || updateVersionsFromContext(ctx) {
+		previous := tag
+
+		tag, err = highestTag(ctx, store, ref, pURL.Version)
+		if err != nil {
+			return reference{}, err
+		}
+
+		if err := store.Resolve(key, tag); err != nil {
+			return reference{}, err
+		}
+
+		if ok && tag != previous {
+			logger.Printf("Resolved %s to %s (was %s)", key, tag, previous)
+		}
+	}
+
+	logger.Debug("resolved", "range", pURL.Version, "tag", tag, "uses", ref.location)
+
+	pURL.Version = tag
+	ref.location = pURL.String()
+
+	origin, err := packageurl.FromString(ref.origin)
+	if err != nil {
+		return reference{}, err
+	}
+	origin.Version = tag
+	ref.origin = origin.String()
+
+	return ref, nil
+}
+
+// highestTag lists the tags of the repository ref points to, returning the highest one that satisfies constraint.
+func highestTag(ctx context.Context, store *uses.Store, ref reference, constraint string) (string, error) {
+	fetcher, err := selectFetcher(ctx, store, ref)
+	if err != nil {
+		return "", err
+	}
+
+	lister, ok := fetcher.(uses.TagLister)
+	if !ok {
+		return "", fmt.Errorf("%T does not support version ranges: %s", fetcher, ref.location)
+	}
+
+	tags, err := lister.Tags(ctx, ref.location)
+	if err != nil {
+		return "", err
+	}
+
+	tag, err := uses.HighestMatch(constraint, tags)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", ref.location, err)
+	}
+
+	return tag, nil
+}
+
+// locate resolves a `uses` reference relative to prev, applies any replacements
+// and rewrite rules in ctx, resolves semver ranges to tags, and enforces the policy in ctx.
+func locate(ctx context.Context, store *uses.Store, u, prev string) (reference, error) {
+	ref, err := resolve(ctx, u, prev)
+	if err != nil {
+		return reference{}, err
+	}
+
+	requested := ref.location
+
+	ref, err = redirect(ctx, ref)
+	if err != nil {
+		return reference{}, err
+	}
+
+	ref, err = resolveVersion(ctx, store, ref)
+	if err != nil {
+		return reference{}, err
+	}
+
+	if ref.location != requested {
+		ref.requested = requested
+	}
+
+	if p, ok := policyFromContext(ctx); ok {
+		if err := p.Check(ref.location); err != nil {
+			return reference{}, err
+		}
+	}
+
+	return ref, nil
+}
+
+// redirect applies any replacements and rewrite rules in ctx to ref.
+func redirect(ctx context.Context, ref reference) (reference, error) {
+	logger := log.FromContext(ctx)
 
-	var f io.ReadCloser
+	if r := replacementsFromContext(ctx); r != nil {
+		next, ok, err := r.Apply(ref.location)
+		if err != nil {
+			return reference{}, err
+		}
+		if ok {
+			logger.Debug("replaced", "uses", ref.location, "with", next)
+			// local replacements are relative to the current directory, not the calling workflow
+			ref, err = resolve(ctx, next, "file:"+DefaultFileName)
+			if err != nil {
+				return reference{}, err
+			}
+		}
+	}
+
+	if r := rewritesFromContext(ctx); r != nil {
+		next, ok, err := r.Apply(ref.location)
+		if err != nil {
+			return reference{}, err
+		}
+		if ok {
+			logger.Debug("rewrote", "uses", ref.location, "to", next)
+			ref, err = resolve(ctx, next, "file:"+DefaultFileName)
+			if err != nil {
+				return reference{}, err
+			}
+		}
+	}
+
+	return ref, nil
+}
+
+// withoutTask returns the location of a workflow file without the task to call.
+func withoutTask(location string) (string, error) {
+	if strings.HasPrefix(location, "pkg:") {
+		pURL, err := packageurl.FromString(location)
+		if err != nil {
+			return "", err
+		}
+		qualifiers := pURL.Qualifiers.Map()
+		delete(qualifiers, "task")
+		pURL.Qualifiers = packageurl.QualifiersFromMap(qualifiers)
+		return pURL.String(), nil
+	}
+
+	uri, err := url.Parse(location)
+	if err != nil {
+		return "", err
+	}
+	q := uri.Query()
+	q.Del("task")
+	uri.RawQuery = q.Encode()
+	return uri.String(), nil
+}
+
+// fetch retrieves the workflow at ref, caching it in the store, and returns its descriptor.
+func fetch(ctx context.Context, store *uses.Store, ref reference) (uses.Descriptor, error) {
+	logger := log.FromContext(ctx)
+
+	fetcher, err := selectFetcher(ctx, store, ref)
+	if err != nil {
+		return uses.Descriptor{}, err
+	}
+
+	logger.Debug("chosen", "fetcher", fmt.Sprintf("%T", fetcher))
 
 	if downloader, ok := fetcher.(uses.Downloader); ok {
-		desc, err := downloader.Describe(ctx, u)
+		desc, err := downloader.Describe(ctx, ref.location)
 		if err != nil {
-			return err
+			return uses.Descriptor{}, err
 		}
 
 		exists, err := store.Exists(desc)
 		if err != nil {
-			return err
+			return uses.Descriptor{}, err
 		}
 
 		if !exists {
-			logger.Debug("caching", "task", u)
-			rc, err := downloader.Fetch(ctx, u)
+			logger.Debug("caching", "task", ref.location)
+			rc, err := downloader.Fetch(ctx, ref.location)
 			if err != nil {
-				return err
+				return uses.Descriptor{}, err
 			}
 			defer rc.Close()
 
 			if err := store.Store(rc); err != nil {
-				return err
+				return uses.Descriptor{}, err
 			}
 		}
 
-		f, err = store.Fetch(desc)
-		if err != nil {
-			return err
-		}
-		defer f.Close()
-	} else {
-		f, err = fetcher.Fetch(ctx, u)
-		if err != nil {
+		return desc, nil
+	}
+
+	rc, err := fetcher.Fetch(ctx, ref.location)
+	if err != nil {
+		return uses.Descriptor{}, err
+	}
+	defer rc.Close()
+
+	b, err := io.ReadAll(rc)
+	if err != nil {
+		return uses.Descriptor{}, err
+	}
+
+	if err := store.Store(bytes.NewReader(b)); err != nil {
+		return uses.Descriptor{}, err
+	}
+
+	return uses.Descriptor{
+		Size: int64(len(b)),
+		Hex:  fmt.Sprintf("%x", sha256.Sum256(b)),
+	}, nil
+}
+
+// read fetches the content at ref into the store and verifies its signature.
+//
+// It returns the content, and the locations and descriptors of the content and its signature.
+func read(ctx context.Context, store *uses.Store, ref reference) ([]byte, uses.RefIndex, error) {
+	desc, err := fetch(ctx, store, ref)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f, err := store.Fetch(desc)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refs := uses.RefIndex{ref.location: desc}
+
+	sigLocation, sigDesc, err := verify(ctx, store, ref, b)
+	if err != nil {
+		return nil, nil, err
+	}
+	if sigLocation != "" {
+		refs[sigLocation] = sigDesc
+	}
+
+	return b, refs, nil
+}
+
+// load fetches, verifies and validates the workflow at ref.
+//
+// It returns the workflow alongside the descriptors of everything fetched to load it,
+// keyed by location: the workflow itself and its signature, if one was required.
+func load(ctx context.Context, store *uses.Store, ref reference) (Workflow, uses.RefIndex, error) {
+	// verify the signature before parsing, so unsigned content is never interpreted
+	b, refs, err := read(ctx, store, ref)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	wf, err := ReadAndValidate(bytes.NewReader(b))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// reject a remote workflow before running any of it if it references anything the policy forbids
+	if err := ValidatePolicy(ctx, wf, ref.origin); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", ref.location, err)
+	}
+
+	return wf, refs, nil
+}
+
+// ExecuteUses runs a task from a remote workflow source.
+func ExecuteUses(ctx context.Context, store *uses.Store, u string, with With, prev string, dry bool) error {
+	logger := log.FromContext(ctx)
+	logger.Debug("using", "task", u)
+
+	ref, err := locate(ctx, store, u, prev)
+	if err != nil {
+		return err
+	}
+
+	wf, refs, err := load(ctx, store, ref)
+	if err != nil {
+		return err
+	}
+
+	// nothing is run during a dry run, so there is nothing to review
+	if !dry {
+		if err := review(ctx, store, ref, refs[ref.location]); err != nil {
 			return err
 		}
-		defer f.Close()
 	}
 
-	if f == nil {
-		return fmt.Errorf("failed to fetch %s referenced by %s", u, prev)
+	return Run(ctx, store, wf, ref.task, with, ref.origin, dry)
+}
+
+// Open reads the workflow at a `uses` reference, resolved against the current directory,
+// so it can be run as the root workflow. It returns the workflow and its origin.
+//
+// Unless dry is set, changes to remote workflows are reviewed like in ExecuteUses.
+func Open(ctx context.Context, store *uses.Store, u string, dry bool) (Workflow, string, error) {
+	ref, err := locate(ctx, store, u, "file:"+DefaultFileName)
+	if err != nil {
+		return nil, "", err
 	}
 
-	wf, err := ReadAndValidate(f)
+	wf, refs, err := load(ctx, store, ref)
 	if err != nil {
-		return err
+		return nil, "", err
 	}
 
-	taskName := uri.Query().Get("task")
+	if !dry {
+		if err := review(ctx, store, ref, refs[ref.location]); err != nil {
+			return nil, "", err
+		}
+	}
 
-	return Run(ctx, store, wf, taskName, with, next.String(), dry)
+	return wf, ref.origin, nil
 }
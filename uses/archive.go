@@ -0,0 +1,213 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package uses
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/spf13/afero"
+)
+
+// archiveExts are the file extensions of supported archives.
+var archiveExts = []string{".tar.gz", ".tgz", ".zip"}
+
+// archiveCache keeps remote archives downloaded during a run in memory, so every file read from an archive,
+// including its signature, comes from a single download.
+type archiveCache struct {
+	mu      sync.Mutex
+	entries map[string]*archiveEntry
+}
+
+// archiveEntry is a remote archive, sem is held while it is downloaded.
+type archiveEntry struct {
+	sem chan struct{}
+	b   []byte
+}
+
+type archiveCacheKey struct{}
+
+// WithArchiveCache returns a copy of ctx in which each remote archive is downloaded at most once,
+// and kept in memory for as long as ctx is used.
+func WithArchiveCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, archiveCacheKey{}, &archiveCache{entries: make(map[string]*archiveEntry)})
+}
+
+// download returns the archive at u, downloading it unless it is already cached.
+//
+// Failed downloads are not cached, the next caller tries again with its own context.
+func (c *archiveCache) download(ctx context.Context, u string) ([]byte, error) {
+	c.mu.Lock()
+	e, ok := c.entries[u]
+	if !ok {
+		e = &archiveEntry{sem: make(chan struct{}, 1)}
+		c.entries[u] = e
+	}
+	c.mu.Unlock()
+
+	select {
+	case e.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-e.sem }()
+
+	if e.b != nil {
+		return e.b, nil
+	}
+
+	b, err := download(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	e.b = b
+	return b, nil
+}
+
+// IsArchive reports whether uri is a local or remote archive, based on the extension of its path.
+func IsArchive(uri *url.URL) bool {
+	switch uri.Scheme {
+	case "file", "http", "https":
+	default:
+		return false
+	}
+
+	p := uri.Opaque
+	if p == "" {
+		p = uri.Path
+	}
+
+	for _, ext := range archiveExts {
+		if strings.HasSuffix(p, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// ArchiveFetcher fetches a file from within a tar.gz or zip archive.
+//
+// The archive is a local file or is downloaded over HTTP(S), and the file within it is named by the fragment,
+// eg. https://example.com/tasks-v1.tar.gz#ci/vai.yaml
+type ArchiveFetcher struct {
+	fs afero.Fs
+}
+
+// NewArchiveFetcher creates a new archive fetcher, reading local archives from fs
+func NewArchiveFetcher(fs afero.Fs) *ArchiveFetcher {
+	return &ArchiveFetcher{fs}
+}
+
+// Fetch the file
+func (f *ArchiveFetcher) Fetch(ctx context.Context, uses string) (io.ReadCloser, error) {
+	uri, err := url.Parse(uses)
+	if err != nil {
+		return nil, err
+	}
+
+	name := path.Clean(strings.TrimPrefix(uri.Fragment, "/"))
+	uri.Fragment = ""
+
+	b, err := f.open(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+
+	var content []byte
+	if strings.HasSuffix(uri.Opaque+uri.Path, ".zip") {
+		content, err = extractZip(b, name)
+	} else {
+		content, err = extractTarGz(b, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", uses, err)
+	}
+
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
+
+// open reads the whole archive, zip files cannot be read as a stream.
+func (f *ArchiveFetcher) open(ctx context.Context, uri *url.URL) ([]byte, error) {
+	if uri.Scheme == "file" {
+		p := uri.Opaque
+		if p == "" {
+			// absolute paths (file:/abs/tasks.tar.gz) are not opaque
+			p = uri.Path
+		}
+		return afero.ReadFile(f.fs, p)
+	}
+
+	if c, ok := ctx.Value(archiveCacheKey{}).(*archiveCache); ok {
+		return c.download(ctx, uri.String())
+	}
+	return download(ctx, uri.String())
+}
+
+// download reads the whole response body of a GET request.
+func download(ctx context.Context, u string) ([]byte, error) {
+	rc, err := get(ctx, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// archivePath normalizes the name of an archive entry, which may be prefixed with ./
+func archivePath(name string) string {
+	return path.Clean(strings.TrimPrefix(name, "./"))
+}
+
+func extractTarGz(b []byte, name string) ([]byte, error) {
+	gr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%s not found in archive", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag == tar.TypeReg && archivePath(hdr.Name) == name {
+			return io.ReadAll(tr)
+		}
+	}
+}
+
+func extractZip(b []byte, name string) ([]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, zf := range zr.File {
+		if zf.FileInfo().IsDir() || archivePath(zf.Name) != name {
+			continue
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return io.ReadAll(rc)
+	}
+
+	return nil, fmt.Errorf("%s not found in archive", name)
+}
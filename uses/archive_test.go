@@ -0,0 +1,180 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package uses
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func newTarGz(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+	return buf.Bytes()
+}
+
+func newZip(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestIsArchive(t *testing.T) {
+	testCases := map[string]bool{
+		"file:tasks.tar.gz":                   true,
+		"file:/abs/tasks.tgz#vai.yaml":        true,
+		"https://example.com/tasks.zip":       true,
+		"http://example.com/tasks.tar.gz?x=1": true,
+		"file:vai.yaml":                       false,
+		"https://example.com/vai.yaml":        false,
+		"oci://example.com/tasks.zip":         false,
+	}
+
+	for raw, expected := range testCases {
+		uri, err := url.Parse(raw)
+		require.NoError(t, err)
+		require.Equal(t, expected, IsArchive(uri), raw)
+	}
+}
+
+func TestArchiveFetcher(t *testing.T) {
+	files := map[string]string{
+		"./vai.yaml":    "default:\n  - run: echo 'root'\n",
+		"ci/vai.yaml":   "default:\n  - run: echo 'ci'\n",
+		"ci/other.yaml": "default:\n  - run: echo 'other'\n",
+	}
+	tgz := newTarGz(t, files)
+	zipped := newZip(t, files)
+
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "dist/tasks.tar.gz", tgz, 0644))
+	require.NoError(t, afero.WriteFile(fs, "/abs/tasks.zip", zipped, 0644))
+
+	requests := 0
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/tasks.tgz":
+			_, _ = w.Write(tgz)
+		case "/tasks.zip":
+			_, _ = w.Write(zipped)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	f := NewArchiveFetcher(fs)
+	ctx := context.Background()
+
+	testCases := []struct {
+		uses        string
+		expected    string
+		expectedErr string
+	}{
+		{
+			uses:     "file:dist/tasks.tar.gz#vai.yaml",
+			expected: files["./vai.yaml"],
+		},
+		{
+			uses:     "file:/abs/tasks.zip#ci/vai.yaml",
+			expected: files["ci/vai.yaml"],
+		},
+		{
+			uses:     server.URL + "/tasks.tgz#ci/other.yaml",
+			expected: files["ci/other.yaml"],
+		},
+		{
+			uses:     server.URL + "/tasks.zip#/ci/../vai.yaml",
+			expected: files["./vai.yaml"],
+		},
+		{
+			uses:        "file:dist/tasks.tar.gz#dne.yaml",
+			expectedErr: "file:dist/tasks.tar.gz#dne.yaml: dne.yaml not found in archive",
+		},
+		{
+			uses:        server.URL + "/tasks.zip#dne.yaml",
+			expectedErr: server.URL + "/tasks.zip#dne.yaml: dne.yaml not found in archive",
+		},
+		{
+			uses:        server.URL + "/dne.tar.gz#vai.yaml",
+			expectedErr: "failed to fetch " + server.URL + "/dne.tar.gz: 404 Not Found",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.uses, func(t *testing.T) {
+			rc, err := f.Fetch(ctx, tc.uses)
+			if tc.expectedErr != "" {
+				require.EqualError(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			defer rc.Close()
+
+			b, err := io.ReadAll(rc)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, string(b))
+		})
+	}
+
+	// without an archive cache, every file is read from a new download
+	require.Equal(t, 4, requests)
+
+	// with one, each remote archive is downloaded once, however many files are read from it and by how many fetchers
+	cached := WithArchiveCache(ctx)
+	requests = 0
+	for _, member := range []string{"vai.yaml", "ci/vai.yaml", "ci/other.yaml"} {
+		_, err := NewArchiveFetcher(fs).Fetch(cached, server.URL+"/tasks.tgz#"+member)
+		require.NoError(t, err)
+	}
+	require.Equal(t, 1, requests)
+
+	// a caller that gives up does not stop the next one from downloading the archive
+	cancelled, cancel := context.WithCancel(cached)
+	cancel()
+	_, err := f.Fetch(cancelled, server.URL+"/tasks.zip#vai.yaml")
+	require.Error(t, err)
+	_, err = f.Fetch(cached, server.URL+"/tasks.zip#vai.yaml")
+	require.NoError(t, err)
+	require.Equal(t, 2, requests)
+
+	// failed downloads are not cached
+	for range 2 {
+		_, err = f.Fetch(cached, server.URL+"/dne.tar.gz#vai.yaml")
+		require.Error(t, err)
+	}
+	require.Equal(t, 4, requests)
+}
@@ -0,0 +1,177 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package uses
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+
+	"github.com/package-url/packageurl-go"
+)
+
+// BitbucketCloudBase is the API root for bitbucket.org
+const BitbucketCloudBase = "https://api.bitbucket.org/2.0"
+
+// BitbucketClient is a client for fetching files from Bitbucket Cloud or Bitbucket Server / Data Center
+type BitbucketClient struct {
+	base   *url.URL
+	server bool
+	creds  *credentials
+
+	memo memo
+}
+
+// NewBitbucketClient creates a new Bitbucket client
+//
+// If base is empty, files are fetched from bitbucket.org, otherwise base is
+// treated as the root of a self-hosted Bitbucket Server / Data Center instance.
+func NewBitbucketClient(base string) (*BitbucketClient, error) {
+	server := base != ""
+	if !server {
+		base = BitbucketCloudBase
+	}
+
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+
+	host := u.Host
+	if !server {
+		host = "bitbucket.org"
+	}
+
+	creds := &credentials{host: host, env: "BITBUCKET_TOKEN", header: "Authorization", prefix: "Bearer "}
+
+	return &BitbucketClient{base: u, server: server, creds: creds}, nil
+}
+
+// raw returns the URL of the raw file contents for a package URL
+func (b *BitbucketClient) raw(uses string) (string, error) {
+	pURL, err := packageurl.FromString(uses)
+	if err != nil {
+		return "", err
+	}
+
+	if b.server {
+		u := b.base.JoinPath("rest/api/1.0/projects", pURL.Namespace, "repos", pURL.Name, "raw", pURL.Subpath)
+		u.RawQuery = url.Values{"at": []string{pURL.Version}}.Encode()
+		return u.String(), nil
+	}
+
+	return b.base.JoinPath("repositories", pURL.Namespace, pURL.Name, "src", pURL.Version, pURL.Subpath).String(), nil
+}
+
+// download retrieves the raw contents of a file
+func (b *BitbucketClient) download(ctx context.Context, uses string) ([]byte, error) {
+	raw, err := b.raw(uses)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := b.creds.headers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := get(ctx, raw, header)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return io.ReadAll(rc)
+}
+
+// Describe returns a descriptor for the given file
+//
+// Bitbucket does not expose a SHA256 for files, so the file is downloaded and hashed,
+// and kept in memory for a subsequent Fetch.
+func (b *BitbucketClient) Describe(ctx context.Context, uses string) (Descriptor, error) {
+	return b.memo.describe(ctx, uses, b.download)
+}
+
+// Fetch the file
+func (b *BitbucketClient) Fetch(ctx context.Context, uses string) (io.ReadCloser, error) {
+	return b.memo.fetch(ctx, uses, b.download)
+}
+
+// Tags lists the tags of the repository
+func (b *BitbucketClient) Tags(ctx context.Context, uses string) ([]string, error) {
+	pURL, err := packageurl.FromString(uses)
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []string
+
+	if b.server {
+		for start := 0; ; {
+			u := b.base.JoinPath("rest/api/1.0/projects", pURL.Namespace, "repos", pURL.Name, "tags")
+			u.RawQuery = url.Values{"start": []string{fmt.Sprint(start)}, "limit": []string{"100"}}.Encode()
+
+			var page struct {
+				Values []struct {
+					DisplayID string `json:"displayId"`
+				} `json:"values"`
+				IsLastPage    bool `json:"isLastPage"`
+				NextPageStart int  `json:"nextPageStart"`
+			}
+			if err := b.getJSON(ctx, u.String(), &page); err != nil {
+				return nil, err
+			}
+
+			for _, tag := range page.Values {
+				tags = append(tags, tag.DisplayID)
+			}
+
+			if page.IsLastPage {
+				return tags, nil
+			}
+			start = page.NextPageStart
+		}
+	}
+
+	u := b.base.JoinPath("repositories", pURL.Namespace, pURL.Name, "refs/tags")
+	u.RawQuery = url.Values{"pagelen": []string{"100"}}.Encode()
+	next := u.String()
+	for next != "" {
+		var page struct {
+			Values []struct {
+				Name string `json:"name"`
+			} `json:"values"`
+			Next string `json:"next"`
+		}
+		if err := b.getJSON(ctx, next, &page); err != nil {
+			return nil, err
+		}
+
+		for _, tag := range page.Values {
+			tags = append(tags, tag.Name)
+		}
+
+		next = page.Next
+	}
+
+	return tags, nil
+}
+
+// getJSON decodes the JSON response of a GET request into v
+func (b *BitbucketClient) getJSON(ctx context.Context, raw string, v any) error {
+	header, err := b.creds.headers(ctx)
+	if err != nil {
+		return err
+	}
+
+	rc, err := get(ctx, raw, header)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	return json.NewDecoder(rc).Decode(v)
+}
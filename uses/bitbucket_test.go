@@ -0,0 +1,135 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package uses
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBitbucketClient(t *testing.T) {
+	hw := `hello-world: [run: echo "Hello, World!"]`
+
+	requests := 0
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if r.URL.Path == "/rest/api/1.0/projects/noxsios/repos/vai/raw/tasks/vai.yaml" && r.URL.Query().Get("at") == "v1.0.0" {
+			_, _ = w.Write([]byte(hw))
+			return
+		}
+
+		w.WriteHeader(http.StatusNotFound)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	t.Cleanup(func() {
+		server.Close()
+	})
+
+	// tokens from the environment are only sent to the public host
+	withNetrc(t, "machine "+strings.TrimPrefix(server.URL, "http://")+" password secret\n")
+
+	ctx := context.Background()
+
+	client, err := NewBitbucketClient(server.URL)
+	require.NoError(t, err)
+
+	uses := "pkg:bitbucket/noxsios/vai@v1.0.0?base=" + server.URL + "&task=hello-world#tasks/vai.yaml"
+
+	desc, err := client.Describe(ctx, uses)
+	require.NoError(t, err)
+	require.Equal(t, Descriptor{
+		Size: int64(len(hw)),
+		Hex:  fmt.Sprintf("%x", sha256.Sum256([]byte(hw))),
+	}, desc)
+
+	// served from memory after Describe
+	rc, err := client.Fetch(ctx, uses)
+	require.NoError(t, err)
+	b, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.Equal(t, hw, string(b))
+	require.Equal(t, 1, requests)
+
+	// and directly from the server otherwise
+	rc, err = client.Fetch(ctx, uses)
+	require.NoError(t, err)
+	b, err = io.ReadAll(rc)
+	require.NoError(t, err)
+	require.Equal(t, hw, string(b))
+	require.Equal(t, 2, requests)
+
+	_, err = client.Describe(ctx, "pkg:bitbucket/noxsios/vai@v2.0.0#tasks/vai.yaml")
+	require.EqualError(t, err, fmt.Sprintf("failed to fetch %s/rest/api/1.0/projects/noxsios/repos/vai/raw/tasks/vai.yaml?at=v2.0.0: 404 Not Found", server.URL))
+
+	// the environment is not consulted for a self-hosted server
+	t.Setenv("BITBUCKET_TOKEN", "secret")
+	withNetrc(t, "")
+	client, err = NewBitbucketClient(server.URL)
+	require.NoError(t, err)
+	_, err = client.Fetch(ctx, uses)
+	require.ErrorContains(t, err, "401 Unauthorized")
+}
+
+func TestBitbucketCloudURL(t *testing.T) {
+	client, err := NewBitbucketClient("")
+	require.NoError(t, err)
+
+	raw, err := client.raw("pkg:bitbucket/noxsios/vai@main?task=echo#tasks/vai.yaml")
+	require.NoError(t, err)
+	require.Equal(t, "https://api.bitbucket.org/2.0/repositories/noxsios/vai/src/main/tasks/vai.yaml", raw)
+}
+
+func TestBitbucketTags(t *testing.T) {
+	var server *httptest.Server
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/rest/api/1.0/projects/tools/repos/vai/tags":
+			if r.URL.Query().Get("start") == "0" {
+				_, _ = w.Write([]byte(`{"values":[{"displayId":"v1.0.0"},{"displayId":"v1.1.0"}],"isLastPage":false,"nextPageStart":2}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"values":[{"displayId":"v2.0.0"}],"isLastPage":true}`))
+		case "/repositories/noxsios/vai/refs/tags":
+			if r.URL.Query().Get("page") == "" {
+				_, _ = fmt.Fprintf(w, `{"values":[{"name":"v1.0.0"}],"next":"%s/repositories/noxsios/vai/refs/tags?page=2"}`, server.URL)
+				return
+			}
+			_, _ = w.Write([]byte(`{"values":[{"name":"v1.2.0"}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+	server = httptest.NewServer(http.HandlerFunc(handler))
+	t.Cleanup(func() {
+		server.Close()
+	})
+
+	ctx := context.Background()
+
+	client, err := NewBitbucketClient(server.URL)
+	require.NoError(t, err)
+
+	tags, err := client.Tags(ctx, "pkg:bitbucket/TOOLS/vai@^1#vai.yaml")
+	require.NoError(t, err)
+	require.Equal(t, []string{"v1.0.0", "v1.1.0", "v2.0.0"}, tags)
+
+	// exercise the cloud API against the stand-in
+	client.server = false
+	tags, err = client.Tags(ctx, "pkg:bitbucket/noxsios/vai@^1#vai.yaml")
+	require.NoError(t, err)
+	require.Equal(t, []string{"v1.0.0", "v1.2.0"}, tags)
+}
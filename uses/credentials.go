@@ -0,0 +1,305 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package uses
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// CredentialHelperEnv is the environment variable naming an executable that provides per-host tokens.
+//
+// The helper is invoked as `<helper> get <host>` and must print the token to stdout.
+// Printing nothing means the helper has no token for that host.
+const CredentialHelperEnv = "VAI_CREDENTIAL_HELPER"
+
+// defaultHosts maps the token environment variable of each host type to the public host it is meant for.
+var defaultHosts = map[string]string{
+	"GITHUB_TOKEN":    "github.com",
+	"GITLAB_TOKEN":    "gitlab.com",
+	"BITBUCKET_TOKEN": "bitbucket.org",
+	"GITEA_TOKEN":     "gitea.com",
+}
+
+// tokenCache keeps the tokens looked up for each host, so the credential helper is run,
+// and the .netrc file read, at most once per host.
+type tokenCache struct {
+	mu     sync.Mutex
+	tokens map[[2]string]string
+}
+
+// lookup returns the token for host, looking it up with lookupToken unless it is cached.
+//
+// Failed lookups are not cached.
+func (c *tokenCache) lookup(host, env string) (string, error) {
+	// held while looking up, so concurrent requests to a host do not run the helper more than once
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := [2]string{host, env}
+	if token, ok := c.tokens[key]; ok {
+		return token, nil
+	}
+
+	token, err := lookupToken(host, env)
+	if err != nil {
+		return "", err
+	}
+
+	if c.tokens == nil {
+		c.tokens = make(map[[2]string]string)
+	}
+	c.tokens[key] = token
+	return token, nil
+}
+
+type tokenCacheKey struct{}
+
+// WithCredentialCache returns a copy of ctx in which the token for each host is looked up once,
+// and shared by every client for as long as ctx is used.
+func WithCredentialCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, tokenCacheKey{}, &tokenCache{})
+}
+
+// credentials is the token a client sends to host, looked up when a request is made.
+type credentials struct {
+	host string
+	// env is the environment variable holding the token for the public host
+	env string
+	// header and prefix make up the header the token is sent in, eg. Authorization: Bearer <token>
+	header string
+	prefix string
+
+	// cache is used when ctx has no credential cache, so a client looks up its token once
+	cache tokenCache
+}
+
+// token returns the token for the host, from the credential cache in ctx if there is one.
+func (c *credentials) token(ctx context.Context) (string, error) {
+	cache, ok := ctx.Value(tokenCacheKey{}).(*tokenCache)
+	if !ok {
+		cache = &c.cache
+	}
+	return cache.lookup(c.host, c.env)
+}
+
+// headers returns the headers to authenticate a request with, empty if there is no token.
+func (c *credentials) headers(ctx context.Context) (http.Header, error) {
+	token, err := c.token(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	header := http.Header{}
+	if token != "" {
+		header.Set(c.header, c.prefix+token)
+	}
+	return header, nil
+}
+
+// credentialsTransport authenticates each request with credentials, for clients that build their own requests.
+type credentialsTransport struct {
+	creds *credentials
+	next  http.RoundTripper
+}
+
+// RoundTrip sets the header of the credentials on a copy of req, or removes it if there is no token.
+func (t *credentialsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.creds.token(req.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.Clone(req.Context())
+	if token != "" {
+		req.Header.Set(t.creds.header, t.creds.prefix+token)
+	} else {
+		req.Header.Del(t.creds.header)
+	}
+
+	next := t.next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return next.RoundTrip(req)
+}
+
+// lookupToken returns the token to use when talking to host.
+//
+// Sources are consulted in order, the first to return a token wins:
+//
+//  1. the credential helper named by VAI_CREDENTIAL_HELPER
+//  2. the password of the matching machine in the .netrc file (NETRC or ~/.netrc)
+//  3. the environment variable env, and the .netrc default entry
+//
+// The last are only used for the public host of env, so a workflow naming another host in `base`
+// is never sent the user's token.
+func lookupToken(host, env string) (string, error) {
+	public := defaultHosts[env] == host
+
+	if helper, ok := os.LookupEnv(CredentialHelperEnv); ok && helper != "" {
+		token, err := helperToken(helper, host)
+		if err != nil {
+			return "", err
+		}
+		if token != "" {
+			return token, nil
+		}
+	}
+
+	token, err := netrcToken(host, public)
+	if err != nil {
+		return "", err
+	}
+	if token != "" || !public {
+		return token, nil
+	}
+
+	return os.Getenv(env), nil
+}
+
+// helperToken runs a credential helper for host.
+func helperToken(helper, host string) (string, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command(helper, "get", host)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("credential helper %s failed for %s: %w: %s", helper, host, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// netrcPath returns the location of the .netrc file.
+func netrcPath() (string, error) {
+	if p, ok := os.LookupEnv("NETRC"); ok {
+		return p, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, ".netrc"), nil
+}
+
+// netrcToken returns the password for host from the .netrc file, if any.
+//
+// The default entry is only used if fallback is set.
+func netrcToken(host string, fallback bool) (string, error) {
+	p, err := netrcPath()
+	if err != nil {
+		// no home directory means no .netrc
+		return "", nil
+	}
+
+	f, err := os.Open(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	machines, err := parseNetrc(f)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", p, err)
+	}
+
+	if token, ok := machines[host]; ok {
+		return token, nil
+	}
+
+	if !fallback {
+		return "", nil
+	}
+
+	// the default entry is stored under the empty host
+	return machines[""], nil
+}
+
+// parseNetrc parses a .netrc file into a map of machine names to passwords.
+//
+// The "default" entry is stored under the empty string. Macro definitions are skipped.
+func parseNetrc(r io.Reader) (map[string]string, error) {
+	machines := map[string]string{}
+
+	scanner := bufio.NewScanner(r)
+
+	var tokens []string
+	inMacro := false
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// a macro definition runs until the next blank line
+		if inMacro {
+			if strings.TrimSpace(line) == "" {
+				inMacro = false
+			}
+			continue
+		}
+
+		fields := strings.Fields(line)
+		for i := 0; i < len(fields); i++ {
+			if strings.HasPrefix(fields[i], "#") {
+				break
+			}
+			if fields[i] == "macdef" {
+				inMacro = true
+				break
+			}
+			tokens = append(tokens, fields[i])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var machine string
+	var seen bool
+	for i := 0; i < len(tokens); i++ {
+		switch tokens[i] {
+		case "machine":
+			if i+1 >= len(tokens) {
+				return nil, errors.New("missing machine name")
+			}
+			i++
+			machine, seen = tokens[i], true
+		case "default":
+			machine, seen = "", true
+		case "login", "account", "password":
+			if i+1 >= len(tokens) {
+				return nil, fmt.Errorf("missing value for %s", tokens[i])
+			}
+			if !seen {
+				return nil, fmt.Errorf("%s outside of a machine entry", tokens[i])
+			}
+			if tokens[i] == "password" {
+				// the first entry for a machine wins
+				if _, ok := machines[machine]; !ok {
+					machines[machine] = tokens[i+1]
+				}
+			}
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected token %q", tokens[i])
+		}
+	}
+
+	return machines, nil
+}
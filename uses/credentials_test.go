@@ -0,0 +1,239 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package uses
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNetrc(t *testing.T) {
+	testCases := []struct {
+		name        string
+		netrc       string
+		expected    map[string]string
+		expectedErr string
+	}{
+		{
+			name:     "empty",
+			netrc:    "",
+			expected: map[string]string{},
+		},
+		{
+			name: "multiple machines",
+			netrc: `machine github.com login x-access-token password gh-token
+machine ghe.example.com
+  login bot
+  password ghe-token
+
+# comment
+default login anonymous password default-token
+`,
+			expected: map[string]string{
+				"github.com":      "gh-token",
+				"ghe.example.com": "ghe-token",
+				"":                "default-token",
+			},
+		},
+		{
+			name: "macdef is skipped",
+			netrc: `machine gitlab.com password gl-token
+macdef init
+cd /pub
+password not-a-token
+
+machine gitea.com password gitea-token
+`,
+			expected: map[string]string{
+				"gitlab.com": "gl-token",
+				"gitea.com":  "gitea-token",
+			},
+		},
+		{
+			name: "first entry wins",
+			netrc: `machine github.com password first
+machine github.com password second`,
+			expected: map[string]string{
+				"github.com": "first",
+			},
+		},
+		{
+			name:        "missing value",
+			netrc:       "machine github.com password",
+			expectedErr: "missing value for password",
+		},
+		{
+			name:        "outside of machine",
+			netrc:       "password token",
+			expectedErr: "password outside of a machine entry",
+		},
+		{
+			name:        "unexpected token",
+			netrc:       "machine github.com token abc",
+			expectedErr: `unexpected token "token"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			machines, err := parseNetrc(strings.NewReader(tc.netrc))
+			if tc.expectedErr != "" {
+				require.EqualError(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, machines)
+		})
+	}
+}
+
+// withNetrc points NETRC at a file containing content for the duration of the test.
+func withNetrc(t *testing.T, content string) {
+	t.Helper()
+
+	p := filepath.Join(t.TempDir(), ".netrc")
+	require.NoError(t, os.WriteFile(p, []byte(content), 0600))
+	t.Setenv("NETRC", p)
+	t.Setenv(CredentialHelperEnv, "")
+}
+
+func TestLookupToken(t *testing.T) {
+	tmp := t.TempDir()
+
+	netrc := filepath.Join(tmp, ".netrc")
+	require.NoError(t, os.WriteFile(netrc, []byte("machine ghe.example.com password netrc-token\n"), 0600))
+
+	t.Setenv("NETRC", netrc)
+	t.Setenv(CredentialHelperEnv, "")
+	t.Setenv("GITHUB_TOKEN", "env-token")
+
+	token, err := lookupToken("ghe.example.com", "GITHUB_TOKEN")
+	require.NoError(t, err)
+	require.Equal(t, "netrc-token", token)
+
+	token, err = lookupToken("github.com", "GITHUB_TOKEN")
+	require.NoError(t, err)
+	require.Equal(t, "env-token", token)
+
+	// the environment and the default entry are only used for the public host
+	t.Setenv("NETRC", filepath.Join(tmp, "dne"))
+	token, err = lookupToken("ghe.example.com", "GITHUB_TOKEN")
+	require.NoError(t, err)
+	require.Empty(t, token)
+
+	token, err = lookupToken("evil.example", "GITHUB_TOKEN")
+	require.NoError(t, err)
+	require.Empty(t, token)
+
+	defaults := filepath.Join(tmp, "defaults")
+	require.NoError(t, os.WriteFile(defaults, []byte("default password default-token\n"), 0600))
+	t.Setenv("NETRC", defaults)
+
+	token, err = lookupToken("evil.example", "GITHUB_TOKEN")
+	require.NoError(t, err)
+	require.Empty(t, token)
+
+	token, err = lookupToken("github.com", "GITHUB_TOKEN")
+	require.NoError(t, err)
+	require.Equal(t, "default-token", token)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("credential helper test uses a shell script")
+	}
+
+	helper := filepath.Join(tmp, "helper")
+	require.NoError(t, os.WriteFile(helper, []byte(`#!/bin/sh
+[ "$1" = "get" ] || exit 2
+case "$2" in
+  ghe.example.com) echo helper-token ;;
+  broken.example.com) echo "no access" >&2; exit 1 ;;
+esac
+`), 0700))
+
+	t.Setenv("NETRC", netrc)
+	t.Setenv(CredentialHelperEnv, helper)
+
+	token, err = lookupToken("ghe.example.com", "GITHUB_TOKEN")
+	require.NoError(t, err)
+	require.Equal(t, "helper-token", token)
+
+	// the helper has nothing for this host, so fall through to the environment
+	token, err = lookupToken("github.com", "GITHUB_TOKEN")
+	require.NoError(t, err)
+	require.Equal(t, "env-token", token)
+
+	_, err = lookupToken("broken.example.com", "GITHUB_TOKEN")
+	require.EqualError(t, err, "credential helper "+helper+" failed for broken.example.com: exit status 1: no access")
+}
+
+func TestCredentialCache(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("credential helper test uses a shell script")
+	}
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token helper-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte("[]"))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	// the helper records every run
+	tmp := t.TempDir()
+	runs := filepath.Join(tmp, "runs")
+	helper := filepath.Join(tmp, "helper")
+	require.NoError(t, os.WriteFile(helper, []byte(`#!/bin/sh
+echo "$2" >> `+runs+`
+echo helper-token
+`), 0700))
+
+	withNetrc(t, "")
+	t.Setenv(CredentialHelperEnv, helper)
+
+	count := func() int {
+		b, err := os.ReadFile(runs)
+		if os.IsNotExist(err) {
+			return 0
+		}
+		require.NoError(t, err)
+		return strings.Count(string(b), "\n")
+	}
+
+	list := func(ctx context.Context) {
+		client, err := NewGiteaClient(server.URL)
+		require.NoError(t, err)
+		for range 2 {
+			_, err = client.Tags(ctx, "pkg:gitea/noxsios/vai@v1.0.0?base="+server.URL)
+			require.NoError(t, err)
+		}
+	}
+
+	// nothing is looked up until a request is made
+	_, err := NewGiteaClient(server.URL)
+	require.NoError(t, err)
+	require.Equal(t, 0, count())
+
+	// once per client
+	ctx := context.Background()
+	list(ctx)
+	list(ctx)
+	require.Equal(t, 2, count())
+
+	// or once per run
+	ctx = WithCredentialCache(ctx)
+	list(ctx)
+	list(ctx)
+	require.Equal(t, 3, count())
+}
@@ -0,0 +1,68 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package uses
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ExecFetcher fetches files by running an external executable.
+//
+// The executable is called with a single argument, the operation, and reads the reference from stdin:
+//
+//   - `fetch` writes the content of the file to stdout
+//   - `tags` writes the tags of the repository to stdout, one per line
+//
+// A non-zero exit status is an error, and stderr is used as its message.
+type ExecFetcher struct {
+	path string
+}
+
+// NewExecFetcher creates a new fetcher that runs the executable at path
+func NewExecFetcher(path string) *ExecFetcher {
+	return &ExecFetcher{path}
+}
+
+// run calls the executable with the given operation, returning its stdout.
+func (f *ExecFetcher) run(ctx context.Context, op, uses string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, f.path, op)
+	cmd.Stdin = strings.NewReader(uses + "\n")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s %s %s: %s", filepath.Base(f.path), op, uses, msg)
+		}
+		return nil, fmt.Errorf("%s %s %s: %w", filepath.Base(f.path), op, uses, err)
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// Fetch the file
+func (f *ExecFetcher) Fetch(ctx context.Context, uses string) (io.ReadCloser, error) {
+	b, err := f.run(ctx, "fetch", uses)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+// Tags lists the tags of the repository
+func (f *ExecFetcher) Tags(ctx context.Context, uses string) ([]string, error) {
+	b, err := f.run(ctx, "tags", uses)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(b)), nil
+}
@@ -0,0 +1,174 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package uses
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/package-url/packageurl-go"
+)
+
+// GitFetcher fetches files from any git remote using the `git` executable
+//
+// References take one of the following forms:
+//
+//	git+https://<host>/<repository>[@<ref>]?task=<task>#<path>
+//	git+ssh://<user>@<host>/<repository>[@<ref>]?task=<task>#<path>
+//	git+file:///<path to repository>[@<ref>]?task=<task>#<path>
+//	pkg:generic/<name>@<ref>?vcs_url=<git+ URL>&task=<task>#<path>
+//
+// If no ref is given, the remote's HEAD is used.
+type GitFetcher struct {
+	memo memo
+}
+
+// NewGitFetcher creates a new git fetcher
+func NewGitFetcher() *GitFetcher {
+	return &GitFetcher{}
+}
+
+// parseGit splits a git reference into the remote URL, ref and file path.
+func parseGit(uses string) (string, string, string, error) {
+	uri, err := url.Parse(uses)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	var remote, ref, p string
+
+	switch {
+	case uri.Scheme == "pkg":
+		pURL, err := packageurl.FromString(uses)
+		if err != nil {
+			return "", "", "", err
+		}
+		vcs := pURL.Qualifiers.Map()["vcs_url"]
+		if vcs == "" {
+			return "", "", "", fmt.Errorf("missing vcs_url qualifier in %s", uses)
+		}
+		if !strings.HasPrefix(vcs, "git+") {
+			return "", "", "", fmt.Errorf("unsupported vcs_url %q, must start with \"git+\"", vcs)
+		}
+		remote, ref, p = strings.TrimPrefix(vcs, "git+"), pURL.Version, pURL.Subpath
+	case strings.HasPrefix(uri.Scheme, "git+"):
+		repo, r, _ := strings.Cut(uri.Path, "@")
+		remote = (&url.URL{
+			Scheme: strings.TrimPrefix(uri.Scheme, "git+"),
+			User:   uri.User,
+			Host:   uri.Host,
+			Path:   repo,
+		}).String()
+		ref, p = r, uri.Fragment
+	default:
+		return "", "", "", fmt.Errorf("scheme is not \"git+\"")
+	}
+
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	// git would read these as options, eg. --upload-pack=<command>
+	if strings.HasPrefix(remote, "-") {
+		return "", "", "", fmt.Errorf("invalid remote %q in %s", remote, uses)
+	}
+	if strings.HasPrefix(ref, "-") {
+		return "", "", "", fmt.Errorf("invalid ref %q in %s", ref, uses)
+	}
+
+	if p == "" {
+		return "", "", "", fmt.Errorf("no file specified in %s", uses)
+	}
+
+	return remote, ref, p, nil
+}
+
+// git runs a git command, returning its stdout.
+func git(ctx context.Context, dir string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", dir}, args...)...)
+	// never block waiting on a credential prompt
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// read performs a shallow, blobless fetch of ref and reads a single file from it.
+//
+// Only the blob for the requested file is downloaded if the remote supports partial clones.
+func (g *GitFetcher) read(ctx context.Context, uses string) ([]byte, error) {
+	remote, ref, p, err := parseGit(uses)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "vai-git-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := git(ctx, dir, "init", "--quiet", "--bare"); err != nil {
+		return nil, err
+	}
+
+	if _, err := git(ctx, dir, "remote", "add", "--end-of-options", "origin", remote); err != nil {
+		return nil, err
+	}
+
+	if _, err := git(ctx, dir, "fetch", "--quiet", "--depth=1", "--filter=blob:none", "--end-of-options", "origin", ref); err != nil {
+		return nil, err
+	}
+
+	return git(ctx, dir, "cat-file", "blob", "FETCH_HEAD:"+p)
+}
+
+// Describe returns a descriptor for the given file
+//
+// The file is read from a shallow fetch, and kept in memory for a subsequent Fetch.
+func (g *GitFetcher) Describe(ctx context.Context, uses string) (Descriptor, error) {
+	return g.memo.describe(ctx, uses, g.read)
+}
+
+// Fetch the file
+func (g *GitFetcher) Fetch(ctx context.Context, uses string) (io.ReadCloser, error) {
+	return g.memo.fetch(ctx, uses, g.read)
+}
+
+// Tags lists the tags of the remote repository
+func (g *GitFetcher) Tags(ctx context.Context, uses string) ([]string, error) {
+	remote, _, _, err := parseGit(uses)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := git(ctx, ".", "ls-remote", "--tags", "--refs", "--end-of-options", remote)
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []string
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		_, ref, ok := strings.Cut(line, "\t")
+		if !ok {
+			continue
+		}
+		tags = append(tags, strings.TrimPrefix(ref, "refs/tags/"))
+	}
+
+	return tags, nil
+}
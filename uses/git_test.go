@@ -0,0 +1,206 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package uses
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newBareRepo creates a bare git repository containing files, tagged as v1.0.0.
+//
+// It returns the path to the repository and the commit SHA.
+func newBareRepo(t *testing.T, files map[string]string) (string, string) {
+	t.Helper()
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	ctx := context.Background()
+	root := t.TempDir()
+	remote := filepath.Join(root, "remote.git")
+	work := filepath.Join(root, "work")
+
+	for name, content := range files {
+		p := filepath.Join(work, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0644))
+	}
+
+	for _, args := range [][]string{
+		{"init", "--quiet", "--bare", remote},
+		{"-C", remote, "config", "uploadpack.allowFilter", "true"},
+		{"-C", work, "init", "--quiet"},
+		{"-C", work, "add", "."},
+		{"-C", work, "-c", "user.name=vai", "-c", "user.email=vai@example.com", "commit", "--quiet", "-m", "init"},
+		{"-C", work, "tag", "v1.0.0"},
+		{"-C", work, "push", "--quiet", "--tags", remote, "HEAD:refs/heads/main"},
+		{"-C", remote, "symbolic-ref", "HEAD", "refs/heads/main"},
+	} {
+		_, err := git(ctx, ".", args...)
+		require.NoError(t, err)
+	}
+
+	sha, err := git(ctx, work, "rev-parse", "HEAD")
+	require.NoError(t, err)
+
+	return remote, strings.TrimSpace(string(sha))
+}
+
+func TestParseGit(t *testing.T) {
+	testCases := []struct {
+		uses        string
+		remote      string
+		ref         string
+		path        string
+		expectedErr string
+	}{
+		{
+			uses:   "git+https://example.com/org/repo.git@v1.0.0?task=echo#tasks/vai.yaml",
+			remote: "https://example.com/org/repo.git",
+			ref:    "v1.0.0",
+			path:   "tasks/vai.yaml",
+		},
+		{
+			uses:   "git+ssh://git@example.com/org/repo.git@feature/branch#vai.yaml",
+			remote: "ssh://git@example.com/org/repo.git",
+			ref:    "feature/branch",
+			path:   "vai.yaml",
+		},
+		{
+			uses:   "git+file:///srv/git/repo.git#vai.yaml",
+			remote: "file:///srv/git/repo.git",
+			ref:    "HEAD",
+			path:   "vai.yaml",
+		},
+		{
+			uses:   "pkg:generic/repo@v1.0.0?vcs_url=git%2Bhttps://example.com/org/repo.git#vai.yaml",
+			remote: "https://example.com/org/repo.git",
+			ref:    "v1.0.0",
+			path:   "vai.yaml",
+		},
+		{
+			uses:        "pkg:generic/repo@v1.0.0#vai.yaml",
+			expectedErr: "missing vcs_url qualifier in pkg:generic/repo@v1.0.0#vai.yaml",
+		},
+		{
+			uses:        "pkg:generic/repo@v1.0.0?vcs_url=svn%2Bhttps://example.com/repo#vai.yaml",
+			expectedErr: `unsupported vcs_url "svn+https://example.com/repo", must start with "git+"`,
+		},
+		{
+			uses:        "git+https://example.com/org/repo.git@v1.0.0",
+			expectedErr: "no file specified in git+https://example.com/org/repo.git@v1.0.0",
+		},
+		{
+			uses:        "git+https://example.com/org/repo.git@--upload-pack=touch%20pwned#vai.yaml",
+			expectedErr: `invalid ref "--upload-pack=touch pwned" in git+https://example.com/org/repo.git@--upload-pack=touch%20pwned#vai.yaml`,
+		},
+		{
+			uses:        "pkg:generic/repo@--upload-pack=touch?vcs_url=git%2Bhttps://example.com/org/repo.git#vai.yaml",
+			expectedErr: `invalid ref "--upload-pack=touch" in pkg:generic/repo@--upload-pack=touch?vcs_url=git%2Bhttps://example.com/org/repo.git#vai.yaml`,
+		},
+		{
+			uses:        "pkg:generic/repo@v1.0.0?vcs_url=git%2B--upload-pack=touch#vai.yaml",
+			expectedErr: `invalid remote "--upload-pack=touch" in pkg:generic/repo@v1.0.0?vcs_url=git%2B--upload-pack=touch#vai.yaml`,
+		},
+		{
+			uses:        "https://example.com/org/repo.git",
+			expectedErr: `scheme is not "git+"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.uses, func(t *testing.T) {
+			remote, ref, p, err := parseGit(tc.uses)
+			if tc.expectedErr != "" {
+				require.EqualError(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.remote, remote)
+			require.Equal(t, tc.ref, ref)
+			require.Equal(t, tc.path, p)
+		})
+	}
+}
+
+func TestGitFetcher(t *testing.T) {
+	hw := `hello-world: [run: echo "Hello, World!"]`
+	remote, sha := newBareRepo(t, map[string]string{
+		"vai.yaml":      `default: [uses: file:tasks/hw.yaml?task=hello-world]`,
+		"tasks/hw.yaml": hw,
+	})
+
+	ctx := context.Background()
+	fetcher := NewGitFetcher()
+
+	expected := Descriptor{
+		Size: int64(len(hw)),
+		Hex:  fmt.Sprintf("%x", sha256.Sum256([]byte(hw))),
+	}
+
+	base := "git+file://" + filepath.ToSlash(remote)
+
+	for _, uses := range []string{
+		base + "#tasks/hw.yaml",
+		base + "@main#tasks/hw.yaml",
+		base + "@v1.0.0#tasks/hw.yaml",
+		base + "@" + sha + "#tasks/hw.yaml",
+		"pkg:generic/remote@v1.0.0?vcs_url=git%2Bfile://" + filepath.ToSlash(remote) + "#tasks/hw.yaml",
+	} {
+		desc, err := fetcher.Describe(ctx, uses)
+		require.NoError(t, err)
+		require.Equal(t, expected, desc)
+
+		// served from memory after Describe
+		rc, err := fetcher.Fetch(ctx, uses)
+		require.NoError(t, err)
+		b, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		require.Equal(t, hw, string(b))
+		require.Empty(t, fetcher.memo.contents)
+
+		// and directly from the remote otherwise
+		rc, err = fetcher.Fetch(ctx, uses)
+		require.NoError(t, err)
+		b, err = io.ReadAll(rc)
+		require.NoError(t, err)
+		require.Equal(t, hw, string(b))
+	}
+
+	tags, err := fetcher.Tags(ctx, base+"#vai.yaml")
+	require.NoError(t, err)
+	require.Equal(t, []string{"v1.0.0"}, tags)
+
+	_, err = fetcher.Describe(ctx, base+"#dne.yaml")
+	require.ErrorContains(t, err, "path 'dne.yaml' does not exist in 'FETCH_HEAD'")
+
+	_, err = fetcher.Fetch(ctx, base+"@v2.0.0#vai.yaml")
+	require.ErrorContains(t, err, "git fetch: exit status 128")
+
+	// options smuggled in as a ref or remote never reach git
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "pwned")
+	for _, uses := range []string{
+		base + "@--upload-pack=touch%20" + filepath.ToSlash(marker) + "#vai.yaml",
+		"pkg:generic/remote@v1.0.0?vcs_url=git%2B--upload-pack=touch%20" + filepath.ToSlash(marker) + "#vai.yaml",
+	} {
+		_, err = fetcher.Fetch(ctx, uses)
+		require.ErrorContains(t, err, "invalid")
+
+		_, err = fetcher.Tags(ctx, uses)
+		require.ErrorContains(t, err, "invalid")
+	}
+	require.NoFileExists(t, marker)
+}
@@ -0,0 +1,166 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package uses
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+
+	"github.com/package-url/packageurl-go"
+)
+
+// GiteaClient is a client for fetching files from Gitea or Forgejo
+type GiteaClient struct {
+	base  *url.URL
+	creds *credentials
+
+	memo memo
+}
+
+// NewGiteaClient creates a new Gitea client
+func NewGiteaClient(base string) (*GiteaClient, error) {
+	if base == "" {
+		base = "https://gitea.com"
+	}
+
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+
+	creds := &credentials{host: u.Host, env: "GITEA_TOKEN", header: "Authorization", prefix: "token "}
+
+	return &GiteaClient{base: u, creds: creds}, nil
+}
+
+// endpoint returns the URL of a repository file API for a package URL
+func (g *GiteaClient) endpoint(uses, api string) (string, error) {
+	pURL, err := packageurl.FromString(uses)
+	if err != nil {
+		return "", err
+	}
+
+	u := g.base.JoinPath("api/v1/repos", pURL.Namespace, pURL.Name, api, pURL.Subpath)
+	u.RawQuery = url.Values{"ref": []string{pURL.Version}}.Encode()
+	return u.String(), nil
+}
+
+// giteaContents is the subset of the contents API response used by vai
+type giteaContents struct {
+	Type     string `json:"type"`
+	Encoding string `json:"encoding"`
+	Content  string `json:"content"`
+}
+
+// contents reads a file through the contents API
+func (g *GiteaClient) contents(ctx context.Context, uses string) ([]byte, error) {
+	endpoint, err := g.endpoint(uses, "contents")
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := g.creds.headers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := get(ctx, endpoint, header)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var contents giteaContents
+	if err := json.NewDecoder(rc).Decode(&contents); err != nil {
+		return nil, err
+	}
+
+	if contents.Type != "file" {
+		return nil, fmt.Errorf("%s is a %s, not a file", uses, contents.Type)
+	}
+
+	if contents.Encoding != "base64" {
+		return nil, fmt.Errorf("unsupported content encoding %q for %s", contents.Encoding, uses)
+	}
+
+	return base64.StdEncoding.DecodeString(contents.Content)
+}
+
+// raw reads a file through the raw API
+func (g *GiteaClient) raw(ctx context.Context, uses string) ([]byte, error) {
+	endpoint, err := g.endpoint(uses, "raw")
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := g.creds.headers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := get(ctx, endpoint, header)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return io.ReadAll(rc)
+}
+
+// Describe returns a descriptor for the given file
+//
+// The contents API returns the file itself, which is kept in memory for a subsequent Fetch.
+func (g *GiteaClient) Describe(ctx context.Context, uses string) (Descriptor, error) {
+	return g.memo.describe(ctx, uses, g.contents)
+}
+
+// Fetch the file
+func (g *GiteaClient) Fetch(ctx context.Context, uses string) (io.ReadCloser, error) {
+	return g.memo.fetch(ctx, uses, g.raw)
+}
+
+// Tags lists the tags of the repository
+func (g *GiteaClient) Tags(ctx context.Context, uses string) ([]string, error) {
+	pURL, err := packageurl.FromString(uses)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := g.creds.headers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []string
+	for page := 1; ; page++ {
+		u := g.base.JoinPath("api/v1/repos", pURL.Namespace, pURL.Name, "tags")
+		u.RawQuery = url.Values{"page": []string{fmt.Sprint(page)}, "limit": []string{"50"}}.Encode()
+
+		rc, err := get(ctx, u.String(), header)
+		if err != nil {
+			return nil, err
+		}
+
+		var list []struct {
+			Name string `json:"name"`
+		}
+		err = json.NewDecoder(rc).Decode(&list)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(list) == 0 {
+			return tags, nil
+		}
+
+		for _, tag := range list {
+			tags = append(tags, tag.Name)
+		}
+	}
+}
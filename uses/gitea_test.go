@@ -0,0 +1,135 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package uses
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGiteaClient(t *testing.T) {
+	hw := `hello-world: [run: echo "Hello, World!"]`
+
+	requests := 0
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("Authorization") != "token secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if r.URL.Query().Get("ref") != "v1.0.0" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/api/v1/repos/noxsios/vai/raw/tasks/vai.yaml":
+			_, _ = w.Write([]byte(hw))
+		case "/api/v1/repos/noxsios/vai/contents/tasks/vai.yaml":
+			_ = json.NewEncoder(w).Encode(giteaContents{
+				Type:     "file",
+				Encoding: "base64",
+				Content:  base64.StdEncoding.EncodeToString([]byte(hw)),
+			})
+		case "/api/v1/repos/noxsios/vai/contents/tasks/lfs.yaml":
+			// the size of an LFS object, not of the content returned
+			_, _ = fmt.Fprintf(w, `{"type": "file", "size": 4096, "encoding": "base64", "content": %q}`,
+				base64.StdEncoding.EncodeToString([]byte(hw)))
+		case "/api/v1/repos/noxsios/vai/contents/tasks":
+			_ = json.NewEncoder(w).Encode(giteaContents{
+				Type: "dir",
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	t.Cleanup(func() {
+		server.Close()
+	})
+
+	// tokens from the environment are only sent to the public host
+	withNetrc(t, "machine "+strings.TrimPrefix(server.URL, "http://")+" password secret\n")
+
+	ctx := context.Background()
+
+	client, err := NewGiteaClient(server.URL)
+	require.NoError(t, err)
+
+	uses := "pkg:gitea/noxsios/vai@v1.0.0?base=" + server.URL + "&task=hello-world#tasks/vai.yaml"
+
+	desc, err := client.Describe(ctx, uses)
+	require.NoError(t, err)
+	require.Equal(t, Descriptor{
+		Size: int64(len(hw)),
+		Hex:  fmt.Sprintf("%x", sha256.Sum256([]byte(hw))),
+	}, desc)
+
+	// served from memory after Describe
+	rc, err := client.Fetch(ctx, uses)
+	require.NoError(t, err)
+	b, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.Equal(t, hw, string(b))
+	require.Equal(t, 1, requests)
+
+	// and directly from the server otherwise
+	rc, err = client.Fetch(ctx, uses)
+	require.NoError(t, err)
+	b, err = io.ReadAll(rc)
+	require.NoError(t, err)
+	require.Equal(t, hw, string(b))
+	require.Equal(t, 2, requests)
+
+	// the descriptor matches the content, whatever size the server reports
+	desc, err = client.Describe(ctx, "pkg:gitea/noxsios/vai@v1.0.0#tasks/lfs.yaml")
+	require.NoError(t, err)
+	require.Equal(t, int64(len(hw)), desc.Size)
+
+	_, err = client.Describe(ctx, "pkg:gitea/noxsios/vai@v1.0.0#tasks")
+	require.EqualError(t, err, "pkg:gitea/noxsios/vai@v1.0.0#tasks is a dir, not a file")
+
+	_, err = client.Fetch(ctx, "pkg:gitea/noxsios/vai@v2.0.0#tasks/vai.yaml")
+	require.EqualError(t, err, fmt.Sprintf("failed to fetch %s/api/v1/repos/noxsios/vai/raw/tasks/vai.yaml?ref=v2.0.0: 404 Not Found", server.URL))
+}
+
+func TestGiteaTags(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/repos/noxsios/vai/tags" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		pages := map[string][]map[string]string{
+			"1": {{"name": "v1.0.0"}, {"name": "v1.1.0"}},
+			"2": {{"name": "v2.0.0"}},
+		}
+		_ = json.NewEncoder(w).Encode(append([]map[string]string{}, pages[r.URL.Query().Get("page")]...))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	t.Cleanup(func() {
+		server.Close()
+	})
+
+	client, err := NewGiteaClient(server.URL)
+	require.NoError(t, err)
+
+	tags, err := client.Tags(context.Background(), "pkg:gitea/noxsios/vai@^1#vai.yaml")
+	require.NoError(t, err)
+	require.Equal(t, []string{"v1.0.0", "v1.1.0", "v2.0.0"}, tags)
+
+	_, err = client.Tags(context.Background(), "pkg:gitea/noxsios/dne@^1#vai.yaml")
+	require.ErrorContains(t, err, "404 Not Found")
+}
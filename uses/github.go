@@ -4,87 +4,184 @@
 package uses
 
 import (
+	"bytes"
 	"context"
-	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
+	"net/url"
+	"time"
 
 	"github.com/google/go-github/v62/github"
 	"github.com/package-url/packageurl-go"
 )
 
+var (
+	// githubMaxRetries is how many times a rate limited request is retried.
+	githubMaxRetries = 3
+	// githubMaxWait is the longest vai will wait for a rate limit to reset before giving up.
+	githubMaxWait = 2 * time.Minute
+	// githubBackoff is the wait before retrying a secondary rate limit that did not specify Retry-After.
+	githubBackoff = time.Second
+)
+
 // GitHubClient is a client for fetching files from GitHub
 type GitHubClient struct {
 	client *github.Client
+
+	memo memo
 }
 
 // NewGitHubClient creates a new GitHub client
-func NewGitHubClient() *GitHubClient {
-	client := github.NewClient(nil)
-
-	token, ok := os.LookupEnv("GITHUB_TOKEN")
-	if ok {
-		client = client.WithAuthToken(token)
+//
+// If base is not empty, it is used as the API URL of a GitHub Enterprise Server instance.
+func NewGitHubClient(base string) (*GitHubClient, error) {
+	host := "github.com"
+	if base != "" {
+		u, err := url.Parse(base)
+		if err != nil {
+			return nil, err
+		}
+		host = u.Host
 	}
-	return &GitHubClient{client}
-}
 
-// Describe returns a descriptor for the given file
-func (g *GitHubClient) Describe(ctx context.Context, uses string) (Descriptor, error) {
-	pURL, err := packageurl.FromString(uses)
-	if err != nil {
-		return Descriptor{}, err
-	}
+	creds := &credentials{host: host, env: "GITHUB_TOKEN", header: "Authorization", prefix: "Bearer "}
+	client := github.NewClient(&http.Client{Transport: &credentialsTransport{creds: creds}})
 
-	rc, content, resp, err := g.client.Repositories.DownloadContentsWithMeta(ctx, pURL.Namespace, pURL.Name, pURL.Subpath, &github.RepositoryContentGetOptions{
-		Ref: pURL.Version,
-	})
-	if err != nil {
-		return Descriptor{}, err
+	if base != "" {
+		var err error
+		client, err = client.WithEnterpriseURLs(base, base)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	defer rc.Close()
+	return &GitHubClient{client: client}, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return Descriptor{}, fmt.Errorf("failed to get contents %s: %s", pURL, resp.Status)
+// download retrieves the raw contents of a file in a single request, retrying if rate limited.
+func (g *GitHubClient) download(ctx context.Context, uses string) ([]byte, error) {
+	pURL, err := packageurl.FromString(uses)
+	if err != nil {
+		return nil, err
 	}
 
-	if content == nil {
-		return Descriptor{}, fmt.Errorf("no content found for %s", pURL)
+	// escape the file path the same way go-github does
+	escaped := (&url.URL{Path: pURL.Subpath}).String()
+	endpoint := fmt.Sprintf("repos/%s/%s/contents/%s", pURL.Namespace, pURL.Name, escaped)
+	if pURL.Version != "" {
+		endpoint += "?" + url.Values{"ref": []string{pURL.Version}}.Encode()
 	}
 
-	hasher := sha256.New()
+	var b []byte
+	err = g.retry(ctx, func() error {
+		req, err := g.client.NewRequest(http.MethodGet, endpoint, nil)
+		if err != nil {
+			return err
+		}
+		// return the file itself instead of JSON metadata, this also lifts the 1MB limit on contents
+		req.Header.Set("Accept", "application/vnd.github.raw+json")
+
+		var buf bytes.Buffer
+		resp, err := g.client.Do(ctx, req, &buf)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("failed to download %s: %s", pURL, resp.Status)
+		}
+		b = buf.Bytes()
+		return nil
+	})
+	return b, err
+}
 
-	if _, err := io.Copy(hasher, rc); err != nil {
-		return Descriptor{}, err
+// retry calls fn until it succeeds, fails with an error other than a rate limit, or runs out of retries.
+func (g *GitHubClient) retry(ctx context.Context, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+
+		wait, limited := githubRetryAfter(err, attempt)
+		if !limited || attempt >= githubMaxRetries {
+			return err
+		}
+		if wait > githubMaxWait {
+			return fmt.Errorf("rate limit resets in %s, not waiting longer than %s: %w", wait.Round(time.Second), githubMaxWait, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
 	}
-
-	return Descriptor{
-		Size: int64(content.GetSize()),
-		Hex:  fmt.Sprintf("%x", hasher.Sum(nil)),
-	}, nil
 }
 
-// Fetch the file
-func (g *GitHubClient) Fetch(ctx context.Context, uses string) (io.ReadCloser, error) {
+// Tags lists the tags of the repository
+func (g *GitHubClient) Tags(ctx context.Context, uses string) ([]string, error) {
 	pURL, err := packageurl.FromString(uses)
 	if err != nil {
 		return nil, err
 	}
 
-	rc, resp, err := g.client.Repositories.DownloadContents(ctx, pURL.Namespace, pURL.Name, pURL.Subpath, &github.RepositoryContentGetOptions{
-		Ref: pURL.Version,
-	})
+	var tags []string
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		var page []*github.RepositoryTag
+		var resp *github.Response
+		err := g.retry(ctx, func() error {
+			var err error
+			page, resp, err = g.client.Repositories.ListTags(ctx, pURL.Namespace, pURL.Name, opts)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, tag := range page {
+			tags = append(tags, tag.GetName())
+		}
+
+		if resp.NextPage == 0 {
+			return tags, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
 
-	if err != nil {
-		return nil, err
+// githubRetryAfter reports whether err is a rate limit error, and how long to wait before retrying.
+//
+// Primary rate limits wait until X-RateLimit-Reset, secondary rate limits honor Retry-After
+// and otherwise back off exponentially.
+func githubRetryAfter(err error, attempt int) (time.Duration, bool) {
+	var rle *github.RateLimitError
+	if errors.As(err, &rle) {
+		return max(time.Until(rle.Rate.Reset.Time), 0) + time.Second, true
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to download %s: %s", pURL, resp.Status)
+	var arle *github.AbuseRateLimitError
+	if errors.As(err, &arle) {
+		if arle.RetryAfter != nil {
+			return *arle.RetryAfter, true
+		}
+		return githubBackoff << attempt, true
 	}
 
-	return rc, nil
+	return 0, false
+}
+
+// Describe returns a descriptor for the given file
+//
+// The file is downloaded and hashed, and kept in memory for a subsequent Fetch.
+func (g *GitHubClient) Describe(ctx context.Context, uses string) (Descriptor, error) {
+	return g.memo.describe(ctx, uses, g.download)
+}
+
+// Fetch the file
+func (g *GitHubClient) Fetch(ctx context.Context, uses string) (io.ReadCloser, error) {
+	return g.memo.fetch(ctx, uses, g.download)
 }
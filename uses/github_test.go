@@ -5,9 +5,18 @@ package uses
 
 import (
 	"context"
+	"crypto/sha256"
+	"fmt"
 	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/google/go-github/v62/github"
 	"github.com/stretchr/testify/require"
 )
 
@@ -20,7 +29,8 @@ func TestGitHubFetcher(t *testing.T) {
 
 	ctx := context.Background()
 
-	client := NewGitHubClient()
+	client, err := NewGitHubClient("")
+	require.NoError(t, err)
 
 	desc, err := client.Describe(ctx, uses)
 	require.NoError(t, err)
@@ -42,3 +52,184 @@ echo:
       message: input
 `, string(b))
 }
+
+func TestGitHubEnterprise(t *testing.T) {
+	hw := `hello-world: [run: echo "Hello, World!"]`
+
+	requests := 0
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		if r.Header.Get("Authorization") != "Bearer ghe-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if r.URL.Path == "/api/v3/repos/noxsios/vai/contents/tasks/vai.yaml" && r.URL.Query().Get("ref") == "main" && r.Header.Get("Accept") == "application/vnd.github.raw+json" {
+			_, _ = w.Write([]byte(hw))
+			return
+		}
+
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"message":"Not Found"}`))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	t.Cleanup(func() {
+		server.Close()
+	})
+
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	netrc := filepath.Join(t.TempDir(), ".netrc")
+	require.NoError(t, os.WriteFile(netrc, []byte("machine "+u.Host+" password ghe-token\n"), 0600))
+	t.Setenv("NETRC", netrc)
+	t.Setenv(CredentialHelperEnv, "")
+	t.Setenv("GITHUB_TOKEN", "public-token")
+
+	client, err := NewGitHubClient(server.URL)
+	require.NoError(t, err)
+	require.Equal(t, server.URL+"/api/v3/", client.client.BaseURL.String())
+
+	uses := "pkg:github/noxsios/vai@main?base=" + server.URL + "&task=hello-world#tasks/vai.yaml"
+
+	ctx := context.Background()
+
+	desc, err := client.Describe(ctx, uses)
+	require.NoError(t, err)
+	require.Equal(t, Descriptor{
+		Size: int64(len(hw)),
+		Hex:  fmt.Sprintf("%x", sha256.Sum256([]byte(hw))),
+	}, desc)
+
+	// served from memory after Describe
+	rc, err := client.Fetch(ctx, uses)
+	require.NoError(t, err)
+	b, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.Equal(t, hw, string(b))
+	require.Equal(t, 1, requests)
+
+	// and directly from the server otherwise
+	rc, err = client.Fetch(ctx, uses)
+	require.NoError(t, err)
+	b, err = io.ReadAll(rc)
+	require.NoError(t, err)
+	require.Equal(t, hw, string(b))
+	require.Equal(t, 2, requests)
+
+	_, err = client.Describe(ctx, "pkg:github/noxsios/vai@main#dne.yaml")
+	require.ErrorContains(t, err, "404 Not Found")
+}
+
+func TestGitHubRateLimit(t *testing.T) {
+	hw := `hello-world: [run: echo "Hello, World!"]`
+
+	requests := 0
+	var limit func(w http.ResponseWriter)
+	handler := func(w http.ResponseWriter, _ *http.Request) {
+		requests++
+		if requests == 1 {
+			limit(w)
+			return
+		}
+		_, _ = w.Write([]byte(hw))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	t.Cleanup(func() {
+		server.Close()
+	})
+
+	t.Setenv(CredentialHelperEnv, "")
+	t.Setenv("NETRC", filepath.Join(t.TempDir(), "dne"))
+
+	ctx := context.Background()
+	uses := "pkg:github/noxsios/vai@main#vai.yaml"
+
+	t.Run("secondary", func(t *testing.T) {
+		requests = 0
+		limit = func(w http.ResponseWriter) {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"message":"You have exceeded a secondary rate limit.","documentation_url":"https://docs.github.com/rest/overview/rate-limits-for-the-rest-api#about-secondary-rate-limits"}`))
+		}
+
+		client, err := NewGitHubClient(server.URL)
+		require.NoError(t, err)
+
+		rc, err := client.Fetch(ctx, uses)
+		require.NoError(t, err)
+		b, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		require.Equal(t, hw, string(b))
+		require.Equal(t, 2, requests)
+	})
+
+	t.Run("primary too long", func(t *testing.T) {
+		requests = 0
+		limit = func(w http.ResponseWriter) {
+			w.Header().Set("X-RateLimit-Limit", "60")
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset", fmt.Sprint(time.Now().Add(time.Hour).Unix()))
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"message":"API rate limit exceeded"}`))
+		}
+
+		client, err := NewGitHubClient(server.URL)
+		require.NoError(t, err)
+
+		_, err = client.Fetch(ctx, uses)
+		require.ErrorContains(t, err, "not waiting longer than 2m0s")
+		require.ErrorContains(t, err, "API rate limit exceeded")
+		require.Equal(t, 1, requests)
+	})
+
+	t.Run("retry after", func(t *testing.T) {
+		reset := time.Now().Add(time.Hour)
+
+		wait, ok := githubRetryAfter(&github.RateLimitError{Rate: github.Rate{Reset: github.Timestamp{Time: reset}}}, 0)
+		require.True(t, ok)
+		require.InDelta(t, time.Hour+time.Second, wait, float64(time.Second))
+
+		wait, ok = githubRetryAfter(&github.AbuseRateLimitError{}, 2)
+		require.True(t, ok)
+		require.Equal(t, 4*githubBackoff, wait)
+
+		_, ok = githubRetryAfter(fmt.Errorf("wrapped: %w", &github.AbuseRateLimitError{}), 0)
+		require.True(t, ok)
+
+		_, ok = githubRetryAfter(io.EOF, 0)
+		require.False(t, ok)
+	})
+}
+
+func TestGitHubTags(t *testing.T) {
+	var server *httptest.Server
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/repos/noxsios/vai/tags" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if r.URL.Query().Get("page") == "" {
+			w.Header().Set("Link", fmt.Sprintf(`<%s/api/v3/repos/noxsios/vai/tags?page=2>; rel="next"`, server.URL))
+			_, _ = w.Write([]byte(`[{"name":"v1.0.0"},{"name":"v1.1.0"}]`))
+			return
+		}
+		_, _ = w.Write([]byte(`[{"name":"v2.0.0"}]`))
+	}
+	server = httptest.NewServer(http.HandlerFunc(handler))
+	t.Cleanup(func() {
+		server.Close()
+	})
+
+	t.Setenv(CredentialHelperEnv, "")
+	t.Setenv("NETRC", filepath.Join(t.TempDir(), "dne"))
+
+	client, err := NewGitHubClient(server.URL)
+	require.NoError(t, err)
+
+	tags, err := client.Tags(context.Background(), "pkg:github/noxsios/vai@^1#vai.yaml")
+	require.NoError(t, err)
+	require.Equal(t, []string{"v1.0.0", "v1.1.0", "v2.0.0"}, tags)
+}
@@ -9,7 +9,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"os"
+	"net/url"
 
 	"github.com/package-url/packageurl-go"
 	gitlab "gitlab.com/gitlab-org/api/client-go"
@@ -26,8 +26,15 @@ func NewGitLabClient(base string) (*GitLabClient, error) {
 		base = "https://gitlab.com"
 	}
 
-	token := os.Getenv("GITLAB_TOKEN")
-	client, err := gitlab.NewClient(token, gitlab.WithBaseURL(base))
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+
+	creds := &credentials{host: u.Host, env: "GITLAB_TOKEN", header: "PRIVATE-TOKEN"}
+	httpClient := &http.Client{Transport: &credentialsTransport{creds: creds}}
+
+	client, err := gitlab.NewClient("", gitlab.WithBaseURL(base), gitlab.WithHTTPClient(httpClient))
 	if err != nil {
 		return nil, err
 	}
@@ -81,3 +88,31 @@ func (g *GitLabClient) Fetch(ctx context.Context, uses string) (io.ReadCloser, e
 
 	return io.NopCloser(bytes.NewReader(b)), nil
 }
+
+// Tags lists the tags of the repository
+func (g *GitLabClient) Tags(ctx context.Context, uses string) ([]string, error) {
+	pURL, err := packageurl.FromString(uses)
+	if err != nil {
+		return nil, err
+	}
+
+	pid := pURL.Namespace + "/" + pURL.Name
+
+	var tags []string
+	opts := &gitlab.ListTagsOptions{ListOptions: gitlab.ListOptions{PerPage: 100}}
+	for {
+		page, resp, err := g.client.Tags.ListTags(pid, opts, gitlab.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, tag := range page {
+			tags = append(tags, tag.Name)
+		}
+
+		if resp.NextPage == 0 {
+			return tags, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
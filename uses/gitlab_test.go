@@ -6,6 +6,8 @@ package uses
 import (
 	"context"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -40,3 +42,30 @@ hello-world:
   - run: echo "Hello, World!"
 `, string(b))
 }
+
+func TestGitLabTags(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.EscapedPath() != "/api/v4/projects/noxsios%2Fvai/repository/tags" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if r.URL.Query().Get("page") == "" {
+			w.Header().Set("X-Next-Page", "2")
+			_, _ = w.Write([]byte(`[{"name":"v1.0.0"},{"name":"v1.1.0"}]`))
+			return
+		}
+		_, _ = w.Write([]byte(`[{"name":"v2.0.0"}]`))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	t.Cleanup(func() {
+		server.Close()
+	})
+
+	client, err := NewGitLabClient(server.URL)
+	require.NoError(t, err)
+
+	tags, err := client.Tags(context.Background(), "pkg:gitlab/noxsios/vai@^1#vai.yaml")
+	require.NoError(t, err)
+	require.Equal(t, []string{"v1.0.0", "v1.1.0", "v2.0.0"}, tags)
+}
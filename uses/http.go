@@ -5,11 +5,200 @@ package uses
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+// DefaultHTTPRetries is the number of times a request is retried after a 5xx or 429 response, or a connection error.
+const DefaultHTTPRetries = 3
+
+// retryWaitMin and retryWaitMax bound the backoff between retries, a Retry-After header is honored within them.
+var (
+	retryWaitMin = 1 * time.Second
+	retryWaitMax = 30 * time.Second
 )
 
+// HTTPRule configures requests to matching hosts.
+//
+// Token, Username, Password and Headers values have environment variables expanded, eg. token: $INTERNAL_TOKEN
+type HTTPRule struct {
+	// Match is a list of patterns matched against the host, with or without its port
+	Match []string `json:"match"`
+	// Token is sent as a bearer token
+	Token string `json:"token,omitempty"`
+	// Username and Password are sent using basic auth
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// Headers are added to every request
+	Headers map[string]string `json:"headers,omitempty"`
+	// CACert is the path to a PEM bundle of certificate authorities trusted in addition to the system pool
+	CACert string `json:"ca-cert,omitempty"`
+	// ClientCert and ClientKey are paths to a PEM certificate and key used for mutual TLS
+	ClientCert string `json:"client-cert,omitempty"`
+	ClientKey  string `json:"client-key,omitempty"`
+	// Timeout is the maximum time for a single request, zero means no limit
+	Timeout time.Duration `json:"timeout,omitempty"`
+	// Retries overrides DefaultHTTPRetries, zero disables retries
+	Retries *int `json:"retries,omitempty"`
+}
+
+// HTTPRules configure requests made by the HTTP based fetchers.
+//
+// The first rule that matches a host applies, hosts without a matching rule use the defaults.
+type HTTPRules []HTTPRule
+
+// Validate checks that every rule has well formed patterns and consistent settings.
+func (r HTTPRules) Validate() error {
+	for i, rule := range r {
+		if len(rule.Match) == 0 {
+			return fmt.Errorf("http[%d] must set match", i)
+		}
+		for _, pattern := range rule.Match {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("http[%d] invalid pattern %q: %w", i, pattern, err)
+			}
+		}
+		if rule.Token != "" && (rule.Username != "" || rule.Password != "") {
+			return fmt.Errorf("http[%d] cannot set both token and username/password", i)
+		}
+		if (rule.ClientCert == "") != (rule.ClientKey == "") {
+			return fmt.Errorf("http[%d] must set both client-cert and client-key", i)
+		}
+		if rule.Timeout < 0 {
+			return fmt.Errorf("http[%d] timeout cannot be negative", i)
+		}
+		if rule.Retries != nil && *rule.Retries < 0 {
+			return fmt.Errorf("http[%d] retries cannot be negative", i)
+		}
+	}
+	return nil
+}
+
+// match returns the index of the first rule matching the host of uri, or -1.
+func (r HTTPRules) match(uri *url.URL) int {
+	for i, rule := range r {
+		for _, pattern := range rule.Match {
+			if ok, _ := path.Match(pattern, uri.Hostname()); ok {
+				return i
+			}
+			if ok, _ := path.Match(pattern, uri.Host); ok {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// httpClients lazily builds and caches a client per rule, so connections are reused.
+type httpClients struct {
+	rules HTTPRules
+
+	mu      sync.Mutex
+	clients map[int]*http.Client
+}
+
+// client returns the client and rule for uri, the rule is empty if none match.
+func (c *httpClients) client(uri *url.URL) (*http.Client, HTTPRule, error) {
+	i := c.rules.match(uri)
+
+	var rule HTTPRule
+	if i >= 0 {
+		rule = c.rules[i]
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if client, ok := c.clients[i]; ok {
+		return client, rule, nil
+	}
+
+	client, err := newHTTPClient(rule)
+	if err != nil {
+		return nil, rule, err
+	}
+	c.clients[i] = client
+	return client, rule, nil
+}
+
+// defaultClients are used when no rules are in the context.
+var defaultClients = &httpClients{clients: make(map[int]*http.Client)}
+
+type httpRulesKey struct{}
+
+// WithHTTPRules returns a copy of ctx in which HTTP requests are configured by r.
+func WithHTTPRules(ctx context.Context, r HTTPRules) context.Context {
+	return context.WithValue(ctx, httpRulesKey{}, &httpClients{rules: r, clients: make(map[int]*http.Client)})
+}
+
+func httpClientsFromContext(ctx context.Context) *httpClients {
+	if c, ok := ctx.Value(httpRulesKey{}).(*httpClients); ok {
+		return c
+	}
+	return defaultClients
+}
+
+// newHTTPClient builds a client that retries with backoff, using the TLS settings and timeout of rule.
+func newHTTPClient(rule HTTPRule) (*http.Client, error) {
+	transport := cleanhttp.DefaultPooledTransport()
+
+	if rule.CACert != "" || rule.ClientCert != "" {
+		cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+		if rule.CACert != "" {
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				pool = x509.NewCertPool()
+			}
+			b, err := os.ReadFile(rule.CACert)
+			if err != nil {
+				return nil, err
+			}
+			if !pool.AppendCertsFromPEM(b) {
+				return nil, fmt.Errorf("%s: no certificates found", rule.CACert)
+			}
+			cfg.RootCAs = pool
+		}
+
+		if rule.ClientCert != "" {
+			cert, err := tls.LoadX509KeyPair(rule.ClientCert, rule.ClientKey)
+			if err != nil {
+				return nil, err
+			}
+			cfg.Certificates = []tls.Certificate{cert}
+		}
+
+		transport.TLSClientConfig = cfg
+	}
+
+	retries := DefaultHTTPRetries
+	if rule.Retries != nil {
+		retries = *rule.Retries
+	}
+
+	rc := retryablehttp.NewClient()
+	rc.HTTPClient = &http.Client{Transport: transport, Timeout: rule.Timeout}
+	rc.RetryMax = retries
+	rc.RetryWaitMin = retryWaitMin
+	rc.RetryWaitMax = retryWaitMax
+	rc.Logger = nil
+	// return the last response or error as is, instead of a generic "giving up" error
+	rc.ErrorHandler = retryablehttp.PassthroughErrorHandler
+
+	return rc.StandardClient(), nil
+}
+
 // HTTPFetcher fetches a file from a remote HTTP server
 type HTTPFetcher struct{}
 
@@ -18,20 +207,46 @@ func NewHTTPFetcher() *HTTPFetcher {
 	return &HTTPFetcher{}
 }
 
-// Fetch performs a GET request using the default HTTP client
-// against the provided raw URL string and returns the request body
+// Fetch performs a GET request against the provided raw URL string and returns the request body
 func (f *HTTPFetcher) Fetch(ctx context.Context, raw string) (io.ReadCloser, error) {
+	return get(ctx, raw, nil)
+}
+
+// get performs a GET request with the given headers, using the client configured for the host by the HTTPRules in ctx,
+// and returns the response body if the server responded with 200 OK
+//
+// Headers set by the caller take precedence over the auth and headers of the rule.
+func get(ctx context.Context, raw string, header http.Header) (io.ReadCloser, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, raw, nil)
 	if err != nil {
 		return nil, err
 	}
+
+	client, rule, err := httpClientsFromContext(ctx).client(req.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range rule.Headers {
+		req.Header.Set(k, os.ExpandEnv(v))
+	}
+	switch {
+	case rule.Token != "":
+		req.Header.Set("Authorization", "Bearer "+os.ExpandEnv(rule.Token))
+	case rule.Username != "" || rule.Password != "":
+		req.SetBasicAuth(os.ExpandEnv(rule.Username), os.ExpandEnv(rule.Password))
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
 	req.Header.Set("User-Agent", "vai")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
 		return nil, fmt.Errorf("failed to fetch %s: %s", raw, resp.Status)
 	}
 	return resp.Body, nil
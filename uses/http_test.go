@@ -5,15 +5,33 @@ package uses
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"fmt"
 	"io"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
 
+func TestMain(m *testing.M) {
+	// keep retries quick
+	retryWaitMin, retryWaitMax = time.Millisecond, 10*time.Millisecond
+	os.Exit(m.Run())
+}
+
 func TestHTTPFetcher(t *testing.T) {
 	fetcher := NewHTTPFetcher()
 	ctx := context.Background()
@@ -51,3 +69,183 @@ func TestHTTPFetcher(t *testing.T) {
 	require.EqualError(t, err, fmt.Sprintf("Get \"%s/hello-world.yaml\": dial tcp %s: connect: connection refused", server.URL, server.Listener.Addr()))
 	require.Nil(t, rc)
 }
+
+func TestHTTPRules(t *testing.T) {
+	t.Setenv("INTERNAL_TOKEN", "s3cr3t")
+
+	var attempts atomic.Int32
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/flaky":
+			if attempts.Add(1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		case "/limited":
+			attempts.Add(1)
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		case "/auth":
+			user, pass, ok := r.BasicAuth()
+			if r.Header.Get("Authorization") == "Bearer s3cr3t" || (ok && user == "vai" && pass == "s3cr3t") {
+				_, _ = w.Write([]byte(r.Header.Get("X-Team")))
+				return
+			}
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		case "/slow":
+			time.Sleep(100 * time.Millisecond)
+		}
+		_, _ = w.Write([]byte("ok"))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	read := func(ctx context.Context, p string) (string, error) {
+		rc, err := NewHTTPFetcher().Fetch(ctx, server.URL+p)
+		if err != nil {
+			return "", err
+		}
+		defer rc.Close()
+		b, err := io.ReadAll(rc)
+		return string(b), err
+	}
+
+	ctx := context.Background()
+
+	// 5xx responses are retried by default
+	b, err := read(ctx, "/flaky")
+	require.NoError(t, err)
+	require.Equal(t, "ok", b)
+	require.EqualValues(t, 3, attempts.Load())
+
+	attempts.Store(0)
+	_, err = read(ctx, "/limited")
+	require.EqualError(t, err, fmt.Sprintf("failed to fetch %s/limited: 429 Too Many Requests", server.URL))
+	require.EqualValues(t, DefaultHTTPRetries+1, attempts.Load())
+
+	_, err = read(ctx, "/auth")
+	require.EqualError(t, err, fmt.Sprintf("failed to fetch %s/auth: 401 Unauthorized", server.URL))
+
+	none := 0
+	ctx = WithHTTPRules(context.Background(), HTTPRules{
+		{Match: []string{"example.com"}, Token: "wrong"},
+		{Match: []string{"127.0.0.*"}, Token: "$INTERNAL_TOKEN", Headers: map[string]string{"X-Team": "platform"}, Retries: &none, Timeout: 50 * time.Millisecond},
+	})
+
+	b, err = read(ctx, "/auth")
+	require.NoError(t, err)
+	require.Equal(t, "platform", b)
+
+	attempts.Store(0)
+	_, err = read(ctx, "/limited")
+	require.Error(t, err)
+	require.EqualValues(t, 1, attempts.Load())
+
+	_, err = read(ctx, "/slow")
+	require.ErrorContains(t, err, "Client.Timeout exceeded")
+
+	ctx = WithHTTPRules(context.Background(), HTTPRules{
+		{Match: []string{server.Listener.Addr().String()}, Username: "vai", Password: "$INTERNAL_TOKEN"},
+	})
+	b, err = read(ctx, "/auth")
+	require.NoError(t, err)
+	require.Equal(t, "", b)
+}
+
+func TestHTTPRulesTLS(t *testing.T) {
+	dir := t.TempDir()
+
+	clientCert, clientKey := newClientCert(t, dir)
+	pair, err := tls.LoadX509KeyPair(clientCert, clientKey)
+	require.NoError(t, err)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(pair.Leaf)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	server.TLS = &tls.Config{ClientCAs: clientCAs, ClientAuth: tls.RequireAndVerifyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	caCert := filepath.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(caCert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+
+	none := 0
+	fetch := func(rules HTTPRules) error {
+		rc, err := NewHTTPFetcher().Fetch(WithHTTPRules(context.Background(), rules), server.URL)
+		if err == nil {
+			rc.Close()
+		}
+		return err
+	}
+
+	// the server is not trusted by the system pool
+	require.ErrorContains(t, fetch(HTTPRules{{Match: []string{"*"}, Retries: &none}}), "certificate")
+
+	// the server requires a client certificate
+	require.Error(t, fetch(HTTPRules{{Match: []string{"*"}, CACert: caCert, Retries: &none}}))
+
+	require.NoError(t, fetch(HTTPRules{{Match: []string{"*"}, CACert: caCert, ClientCert: clientCert, ClientKey: clientKey}}))
+
+	require.ErrorContains(t, fetch(HTTPRules{{Match: []string{"*"}, CACert: clientKey}}), "no certificates found")
+}
+
+// newClientCert writes a self-signed client certificate and its key to dir.
+func newClientCert(t *testing.T, dir string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "vai"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPath, keyPath := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	require.NoError(t, os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certPath, keyPath
+}
+
+func TestHTTPRulesValidate(t *testing.T) {
+	negative := -1
+	testCases := []struct {
+		name  string
+		rules HTTPRules
+		err   string
+	}{
+		{"valid", HTTPRules{{Match: []string{"*.internal"}, Token: "t", ClientCert: "c", ClientKey: "k"}}, ""},
+		{"no match", HTTPRules{{Token: "t"}}, "http[0] must set match"},
+		{"bad pattern", HTTPRules{{Match: []string{"["}}}, `http[0] invalid pattern "[": syntax error in pattern`},
+		{"token and basic", HTTPRules{{Match: []string{"*"}, Token: "t", Username: "u"}}, "http[0] cannot set both token and username/password"},
+		{"cert without key", HTTPRules{{Match: []string{"*"}, ClientCert: "c"}}, "http[0] must set both client-cert and client-key"},
+		{"negative timeout", HTTPRules{{Match: []string{"*"}, Timeout: -time.Second}}, "http[0] timeout cannot be negative"},
+		{"negative retries", HTTPRules{{Match: []string{"*"}, Retries: &negative}}, "http[0] retries cannot be negative"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.rules.Validate()
+			if tc.err == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.EqualError(t, err, tc.err)
+		})
+	}
+}
@@ -35,6 +35,10 @@ func (f *LocalFetcher) Describe(_ context.Context, uses string) (Descriptor, err
 	}
 
 	p := uri.Opaque
+	if p == "" {
+		// absolute paths (file:/abs/vai.yaml) are not opaque
+		p = uri.Path
+	}
 
 	fi, err := f.fs.Stat(p)
 	if err != nil {
@@ -70,5 +74,9 @@ func (f *LocalFetcher) Fetch(_ context.Context, uses string) (io.ReadCloser, err
 	}
 
 	p := uri.Opaque
+	if p == "" {
+		// absolute paths (file:/abs/vai.yaml) are not opaque
+		p = uri.Path
+	}
 	return f.fs.Open(p)
 }
@@ -29,6 +29,12 @@ func TestLocalFetcher(t *testing.T) {
 			desc: Descriptor{Hex: "09ca7e4eaa6e8ae9c7d261167129184883644d07dfba7cbfbc4c8a2e08360d5b", Size: 12},
 			rc:   io.NopCloser(strings.NewReader("hello, world")),
 		},
+		{
+			name: "absolute path",
+			uses: "file:/abs/foo.yaml",
+			desc: Descriptor{Hex: "09ca7e4eaa6e8ae9c7d261167129184883644d07dfba7cbfbc4c8a2e08360d5b", Size: 12},
+			rc:   io.NopCloser(strings.NewReader("hello, world")),
+		},
 		{
 			name:                "file does not exist",
 			uses:                "file:baz.yaml",
@@ -54,6 +60,9 @@ func TestLocalFetcher(t *testing.T) {
 	err := afero.WriteFile(fs, "foo.yaml", []byte("hello, world"), 0644)
 	require.NoError(t, err)
 
+	err = afero.WriteFile(fs, "/abs/foo.yaml", []byte("hello, world"), 0644)
+	require.NoError(t, err)
+
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			fetcher := NewLocalFetcher(fs)
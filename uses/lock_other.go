@@ -0,0 +1,20 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd || windows)
+
+package uses
+
+import (
+	"errors"
+	"os"
+)
+
+// tryLockFile reports that advisory file locks are not supported, so stores fall back to an in-process lock.
+func tryLockFile(_ *os.File) (bool, error) {
+	return false, errors.ErrUnsupported
+}
+
+func unlockFile(_ *os.File) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,59 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package uses
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// memo keeps files read during Describe in memory, so the Fetch that follows does not read them again.
+//
+// The zero value is ready to use.
+type memo struct {
+	mu       sync.Mutex
+	contents map[string][]byte
+}
+
+// describe reads the file at uses, keeping it for the next fetch, and returns a descriptor of its content.
+func (m *memo) describe(ctx context.Context, uses string, read func(context.Context, string) ([]byte, error)) (Descriptor, error) {
+	b, err := read(ctx, uses)
+	if err != nil {
+		return Descriptor{}, err
+	}
+
+	m.mu.Lock()
+	if m.contents == nil {
+		m.contents = make(map[string][]byte)
+	}
+	m.contents[uses] = b
+	m.mu.Unlock()
+
+	return Descriptor{
+		Size: int64(len(b)),
+		Hex:  fmt.Sprintf("%x", sha256.Sum256(b)),
+	}, nil
+}
+
+// fetch returns the file kept by describe, or reads it again if there is none.
+func (m *memo) fetch(ctx context.Context, uses string, read func(context.Context, string) ([]byte, error)) (io.ReadCloser, error) {
+	m.mu.Lock()
+	b, ok := m.contents[uses]
+	delete(m.contents, uses)
+	m.mu.Unlock()
+
+	if !ok {
+		var err error
+		b, err = read(ctx, uses)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
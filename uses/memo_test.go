@@ -0,0 +1,58 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package uses
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemo(t *testing.T) {
+	hw := `hello-world: [run: echo "Hello, World!"]`
+
+	reads := 0
+	read := func(_ context.Context, uses string) ([]byte, error) {
+		reads++
+		if uses != "hw" {
+			return nil, fmt.Errorf("%s not found", uses)
+		}
+		return []byte(hw), nil
+	}
+
+	ctx := context.Background()
+	var m memo
+
+	desc, err := m.describe(ctx, "hw", read)
+	require.NoError(t, err)
+	require.Equal(t, Descriptor{
+		Size: int64(len(hw)),
+		Hex:  fmt.Sprintf("%x", sha256.Sum256([]byte(hw))),
+	}, desc)
+
+	fetch := func() {
+		rc, err := m.fetch(ctx, "hw", read)
+		require.NoError(t, err)
+		b, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		require.Equal(t, hw, string(b))
+	}
+
+	// served from memory after describe
+	fetch()
+	require.Equal(t, 1, reads)
+
+	// and read again otherwise
+	fetch()
+	require.Equal(t, 2, reads)
+	require.Empty(t, m.contents)
+
+	_, err = m.describe(ctx, "dne", read)
+	require.EqualError(t, err, "dne not found")
+	require.Empty(t, m.contents)
+}
@@ -0,0 +1,189 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package uses
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"slices"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+const (
+	// OCIArtifactType is the config media type of a published workflow.
+	OCIArtifactType types.MediaType = "application/vnd.vai.workflow.config.v1+json"
+	// OCILayerMediaType is the media type of each workflow file within a published workflow.
+	OCILayerMediaType types.MediaType = "application/vnd.vai.workflow.layer.v1+yaml"
+	// OCIAnnotationTitle is the annotation holding the path of a workflow file within a published workflow.
+	OCIAnnotationTitle = "org.opencontainers.image.title"
+)
+
+// OCIClient is a client for fetching workflows stored as OCI artifacts
+//
+// References take the form `oci://<registry>/<repository>[:<tag>|@<digest>]#<path>`,
+// where path is the file within the artifact.
+type OCIClient struct {
+	options []remote.Option
+}
+
+// NewOCIClient creates a new OCI client
+//
+// Credentials are read from the docker config (`~/.docker/config.json`) and its credential helpers.
+func NewOCIClient() *OCIClient {
+	return &OCIClient{
+		options: []remote.Option{
+			remote.WithAuthFromKeychain(authn.DefaultKeychain),
+			remote.WithUserAgent("vai"),
+		},
+	}
+}
+
+// parseOCI splits an `oci:` reference into the artifact reference and the path of the file within it.
+func parseOCI(uses string) (name.Reference, string, error) {
+	uri, err := url.Parse(uses)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if uri.Scheme != "oci" {
+		return nil, "", fmt.Errorf("scheme is not \"oci\"")
+	}
+
+	ref, err := name.ParseReference(uri.Host + uri.Path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if uri.Fragment == "" {
+		return nil, "", fmt.Errorf("no file specified in %s", uses)
+	}
+
+	return ref, uri.Fragment, nil
+}
+
+// layer finds the layer for a file within an artifact
+func (c *OCIClient) layer(ctx context.Context, uses string) (name.Reference, v1.Descriptor, error) {
+	ref, p, err := parseOCI(uses)
+	if err != nil {
+		return nil, v1.Descriptor{}, err
+	}
+
+	desc, err := remote.Get(ref, append(c.options, remote.WithContext(ctx))...)
+	if err != nil {
+		return nil, v1.Descriptor{}, err
+	}
+
+	manifest, err := v1.ParseManifest(bytes.NewReader(desc.Manifest))
+	if err != nil {
+		return nil, v1.Descriptor{}, err
+	}
+
+	i := slices.IndexFunc(manifest.Layers, func(l v1.Descriptor) bool {
+		return l.Annotations[OCIAnnotationTitle] == p
+	})
+	if i == -1 {
+		return nil, v1.Descriptor{}, fmt.Errorf("%s not found in %s", p, ref)
+	}
+
+	return ref, manifest.Layers[i], nil
+}
+
+// Describe returns a descriptor for the given file
+//
+// Only the manifest is fetched, as layers are already content addressed.
+func (c *OCIClient) Describe(ctx context.Context, uses string) (Descriptor, error) {
+	_, layer, err := c.layer(ctx, uses)
+	if err != nil {
+		return Descriptor{}, err
+	}
+
+	if layer.Digest.Algorithm != "sha256" {
+		return Descriptor{}, fmt.Errorf("unsupported digest algorithm: %q", layer.Digest.Algorithm)
+	}
+
+	return Descriptor{
+		Size: layer.Size,
+		Hex:  layer.Digest.Hex,
+	}, nil
+}
+
+// Fetch the file
+func (c *OCIClient) Fetch(ctx context.Context, uses string) (io.ReadCloser, error) {
+	ref, layer, err := c.layer(ctx, uses)
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := remote.Layer(ref.Context().Digest(layer.Digest.String()), append(c.options, remote.WithContext(ctx))...)
+	if err != nil {
+		return nil, err
+	}
+
+	return l.Compressed()
+}
+
+// Publish pushes a set of workflow files as an OCI artifact, returning the digest of the pushed manifest.
+//
+// files maps the path of each file within the artifact to its contents.
+func (c *OCIClient) Publish(ctx context.Context, dst string, files map[string][]byte) (string, error) {
+	uri, err := url.Parse(dst)
+	if err != nil {
+		return "", err
+	}
+
+	if uri.Scheme != "oci" {
+		return "", fmt.Errorf("scheme is not \"oci\"")
+	}
+
+	ref, err := name.ParseReference(uri.Host + uri.Path)
+	if err != nil {
+		return "", err
+	}
+
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		paths = append(paths, p)
+	}
+	slices.Sort(paths)
+
+	img := mutate.MediaType(empty.Image, types.OCIManifestSchema1)
+	img = mutate.ConfigMediaType(img, OCIArtifactType)
+
+	adds := make([]mutate.Addendum, 0, len(paths))
+	for _, p := range paths {
+		adds = append(adds, mutate.Addendum{
+			Layer: static.NewLayer(files[p], OCILayerMediaType),
+			Annotations: map[string]string{
+				OCIAnnotationTitle: p,
+			},
+		})
+	}
+
+	img, err = mutate.Append(img, adds...)
+	if err != nil {
+		return "", err
+	}
+
+	if err := remote.Write(ref, img, append(c.options, remote.WithContext(ctx))...); err != nil {
+		return "", err
+	}
+
+	digest, err := img.Digest()
+	if err != nil {
+		return "", err
+	}
+
+	return digest.String(), nil
+}
@@ -0,0 +1,71 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package uses
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOCIClient(t *testing.T) {
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(server.Close)
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	ctx := context.Background()
+	client := NewOCIClient()
+
+	hw := `hello-world: [run: echo "Hello, World!"]`
+	files := map[string][]byte{
+		"vai.yaml":      []byte(`default: [uses: file:tasks/hw.yaml?task=hello-world]`),
+		"tasks/hw.yaml": []byte(hw),
+	}
+
+	digest, err := client.Publish(ctx, "oci://"+host+"/vai/tasks:v1", files)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(digest, "sha256:"))
+
+	for _, ref := range []string{
+		"oci://" + host + "/vai/tasks:v1#tasks/hw.yaml",
+		"oci://" + host + "/vai/tasks@" + digest + "#tasks/hw.yaml",
+	} {
+		desc, err := client.Describe(ctx, ref)
+		require.NoError(t, err)
+		require.Equal(t, Descriptor{
+			Size: int64(len(hw)),
+			Hex:  fmt.Sprintf("%x", sha256.Sum256([]byte(hw))),
+		}, desc)
+
+		rc, err := client.Fetch(ctx, ref)
+		require.NoError(t, err)
+		b, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		require.NoError(t, rc.Close())
+		require.Equal(t, hw, string(b))
+	}
+
+	_, err = client.Describe(ctx, "oci://"+host+"/vai/tasks:v1#dne.yaml")
+	require.EqualError(t, err, fmt.Sprintf("dne.yaml not found in %s/vai/tasks:v1", host))
+
+	_, err = client.Describe(ctx, "oci://"+host+"/vai/tasks:v1")
+	require.EqualError(t, err, fmt.Sprintf("no file specified in oci://%s/vai/tasks:v1", host))
+
+	_, err = client.Fetch(ctx, "file:vai.yaml")
+	require.EqualError(t, err, `scheme is not "oci"`)
+
+	_, err = client.Describe(ctx, "oci://"+host+"/vai/dne:v1#vai.yaml")
+	require.ErrorContains(t, err, "NAME_UNKNOWN")
+
+	_, err = client.Publish(ctx, "https://"+host+"/vai/tasks:v1", files)
+	require.EqualError(t, err, `scheme is not "oci"`)
+}
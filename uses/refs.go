@@ -0,0 +1,112 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package uses
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+
+	"github.com/package-url/packageurl-go"
+)
+
+// RefIndex maps fully resolved `uses` references to the descriptors of their content.
+type RefIndex map[string]Descriptor
+
+// RefFetcher resolves references using a RefIndex and serves their content from a Store.
+//
+// Without a fallback it never reaches out to the network, and is used to run workflows from a bundle.
+type RefFetcher struct {
+	store    *Store
+	refs     RefIndex
+	fallback Fetcher
+}
+
+// NewRefFetcher creates a new ref fetcher
+//
+// References missing from refs are fetched with fallback, unless it is nil.
+func NewRefFetcher(store *Store, refs RefIndex, fallback Fetcher) *RefFetcher {
+	return &RefFetcher{store, refs, fallback}
+}
+
+// Describe returns the descriptor recorded for the given reference
+//
+// A reference missing from the index is described by the fallback, or read into the store
+// if the fallback cannot describe it.
+func (f *RefFetcher) Describe(ctx context.Context, uses string) (Descriptor, error) {
+	desc, ok := f.refs[uses]
+	if ok {
+		return desc, nil
+	}
+
+	switch fallback := f.fallback.(type) {
+	case nil:
+		return Descriptor{}, fmt.Errorf("%s not found in ref index", uses)
+	case Describer:
+		return fallback.Describe(ctx, uses)
+	default:
+		rc, err := fallback.Fetch(ctx, uses)
+		if err != nil {
+			return Descriptor{}, err
+		}
+		defer rc.Close()
+
+		b, err := io.ReadAll(rc)
+		if err != nil {
+			return Descriptor{}, err
+		}
+
+		if err := f.store.Store(bytes.NewReader(b)); err != nil {
+			return Descriptor{}, err
+		}
+
+		return Descriptor{
+			Size: int64(len(b)),
+			Hex:  fmt.Sprintf("%x", sha256.Sum256(b)),
+		}, nil
+	}
+}
+
+// Fetch opens the stored content for the given reference
+func (f *RefFetcher) Fetch(ctx context.Context, uses string) (io.ReadCloser, error) {
+	if _, ok := f.refs[uses]; !ok && f.fallback != nil {
+		return f.fallback.Fetch(ctx, uses)
+	}
+
+	desc, err := f.Describe(ctx, uses)
+	if err != nil {
+		return nil, err
+	}
+	return f.store.Fetch(desc)
+}
+
+// Tags lists the versions of a package recorded in the index
+//
+// This allows version ranges to be resolved offline to the same versions they were resolved to when indexed.
+// Packages missing from the index are listed by the fallback, if it can list tags.
+func (f *RefFetcher) Tags(ctx context.Context, uses string) ([]string, error) {
+	pURL, err := packageurl.FromString(uses)
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []string
+	for ref := range f.refs {
+		other, err := packageurl.FromString(ref)
+		if err != nil {
+			continue
+		}
+		if other.Type == pURL.Type && other.Namespace == pURL.Namespace && other.Name == pURL.Name {
+			tags = append(tags, other.Version)
+		}
+	}
+
+	if lister, ok := f.fallback.(TagLister); ok && len(tags) == 0 {
+		return lister.Tags(ctx, uses)
+	}
+
+	return tags, nil
+}
@@ -0,0 +1,98 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package uses
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	"os/exec"
+	"slices"
+	"sync"
+)
+
+// ExternalFetcherPrefix is the prefix of executables on $PATH that fetch schemes or package URL types
+// without a registered Fetcher, eg. vai-fetch-s3 for s3:// or pkg:s3/...
+const ExternalFetcherPrefix = "vai-fetch-"
+
+// FetcherFactory creates a Fetcher for a reference.
+type FetcherFactory func(uri *url.URL) (Fetcher, error)
+
+var fetchers = struct {
+	mu      sync.RWMutex
+	schemes map[string]FetcherFactory
+	types   map[string]FetcherFactory
+}{
+	schemes: make(map[string]FetcherFactory),
+	types:   make(map[string]FetcherFactory),
+}
+
+// RegisterScheme registers a FetcherFactory for a URL scheme, replacing any existing registration.
+//
+// Registering "pkg" replaces the selection of fetchers by package URL type altogether.
+func RegisterScheme(scheme string, factory FetcherFactory) {
+	fetchers.mu.Lock()
+	defer fetchers.mu.Unlock()
+	fetchers.schemes[scheme] = factory
+}
+
+// RegisterType registers a FetcherFactory for a package URL type (pkg:<type>/...), replacing any existing registration.
+func RegisterType(typ string, factory FetcherFactory) {
+	fetchers.mu.Lock()
+	defer fetchers.mu.Unlock()
+	fetchers.types[typ] = factory
+}
+
+// Schemes returns the registered URL schemes in alphabetical order.
+func Schemes() []string {
+	fetchers.mu.RLock()
+	defer fetchers.mu.RUnlock()
+	return slices.Sorted(maps.Keys(fetchers.schemes))
+}
+
+// SupportsScheme reports whether a scheme has a registered Fetcher or an external fetcher on $PATH.
+func SupportsScheme(scheme string) bool {
+	_, err := lookupScheme(scheme)
+	return err == nil
+}
+
+func lookupScheme(scheme string) (FetcherFactory, error) {
+	fetchers.mu.RLock()
+	factory, ok := fetchers.schemes[scheme]
+	fetchers.mu.RUnlock()
+	if ok {
+		return factory, nil
+	}
+	if factory, ok := lookupExternal(scheme); ok {
+		return factory, nil
+	}
+	return nil, fmt.Errorf("unsupported scheme: %q", scheme)
+}
+
+func lookupType(typ string) (FetcherFactory, error) {
+	fetchers.mu.RLock()
+	factory, ok := fetchers.types[typ]
+	fetchers.mu.RUnlock()
+	if ok {
+		return factory, nil
+	}
+	if factory, ok := lookupExternal(typ); ok {
+		return factory, nil
+	}
+	return nil, fmt.Errorf("unsupported type: %q", typ)
+}
+
+// lookupExternal returns a factory for the external fetcher of name, if one is on $PATH.
+func lookupExternal(name string) (FetcherFactory, bool) {
+	if name == "" {
+		return nil, false
+	}
+	p, err := exec.LookPath(ExternalFetcherPrefix + name)
+	if err != nil {
+		return nil, false
+	}
+	return func(_ *url.URL) (Fetcher, error) {
+		return NewExecFetcher(p), nil
+	}, true
+}
@@ -0,0 +1,125 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package uses
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// artifactFetcher stands in for a fetcher registered by a library user.
+type artifactFetcher struct {
+	uri string
+}
+
+func (f *artifactFetcher) Fetch(_ context.Context, uses string) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader(uses)), nil
+}
+
+func TestRegistry(t *testing.T) {
+	RegisterScheme("artifact", func(uri *url.URL) (Fetcher, error) {
+		return &artifactFetcher{uri.String()}, nil
+	})
+	RegisterType("artifact", func(uri *url.URL) (Fetcher, error) {
+		return &artifactFetcher{uri.String()}, nil
+	})
+
+	require.Equal(t, []string{"artifact", "file", "git+file", "git+http", "git+https", "git+ssh", "http", "https", "oci", "pkg"}, Schemes())
+	require.True(t, SupportsScheme("artifact"))
+	require.False(t, SupportsScheme("dne"))
+
+	testCases := []struct {
+		uri  string
+		prev string
+		want string
+	}{
+		{
+			uri:  "artifact://store.internal/tasks/vai.yaml",
+			prev: "file:vai.yaml",
+			want: "artifact://store.internal/tasks/vai.yaml",
+		},
+		{
+			uri:  "file:other.yaml",
+			prev: "artifact://store.internal/tasks/vai.yaml",
+			want: "artifact://store.internal/tasks/vai.yaml",
+		},
+		{
+			uri:  "pkg:artifact/acme/tasks@v1#vai.yaml",
+			prev: "file:vai.yaml",
+			want: "pkg:artifact/acme/tasks@v1#vai.yaml",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.uri, func(t *testing.T) {
+			uri, err := url.Parse(tc.uri)
+			require.NoError(t, err)
+			previous, err := url.Parse(tc.prev)
+			require.NoError(t, err)
+
+			got, err := SelectFetcher(uri, previous)
+			require.NoError(t, err)
+			require.Equal(t, &artifactFetcher{tc.want}, got)
+		})
+	}
+}
+
+func TestExecFetcher(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("external fetchers are shell scripts in this test")
+	}
+
+	dir := t.TempDir()
+	script := `#!/bin/sh
+read -r uses
+case "$1" in
+fetch)
+	case "$uses" in
+	*missing*) echo "not found: $uses" >&2; exit 1 ;;
+	esac
+	printf 'default:\n  - run: echo "%s"\n' "$uses"
+	;;
+tags)
+	printf 'v1.0.0\nv1.1.0\n'
+	;;
+esac
+`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ExternalFetcherPrefix+"s3"), []byte(script), 0755))
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	require.True(t, SupportsScheme("s3"))
+	require.NotContains(t, Schemes(), "s3")
+
+	ctx := context.Background()
+
+	for _, raw := range []string{"s3://bucket/vai.yaml", "pkg:s3/bucket/tasks@v1#vai.yaml"} {
+		uri, err := url.Parse(raw)
+		require.NoError(t, err)
+
+		fetcher, err := SelectFetcher(uri, uri)
+		require.NoError(t, err)
+		require.IsType(t, &ExecFetcher{}, fetcher)
+
+		rc, err := fetcher.Fetch(ctx, raw)
+		require.NoError(t, err)
+		b, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		require.Equal(t, "default:\n  - run: echo \""+raw+"\"\n", string(b))
+
+		tags, err := fetcher.(TagLister).Tags(ctx, raw)
+		require.NoError(t, err)
+		require.Equal(t, []string{"v1.0.0", "v1.1.0"}, tags)
+	}
+
+	_, err := NewExecFetcher(filepath.Join(dir, ExternalFetcherPrefix+"s3")).Fetch(ctx, "s3://bucket/missing.yaml")
+	require.EqualError(t, err, "vai-fetch-s3 fetch s3://bucket/missing.yaml: not found: s3://bucket/missing.yaml")
+}
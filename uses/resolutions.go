@@ -0,0 +1,66 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package uses
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/spf13/afero"
+)
+
+// ResolutionsFileName is the name of the file recording the tag each version range resolved to.
+const ResolutionsFileName = "resolutions.json"
+
+// readResolutions reads the recorded resolutions, a missing file has none.
+func (s *Store) readResolutions() (map[string]string, error) {
+	resolutions := make(map[string]string)
+
+	b, err := afero.ReadFile(s.fs, ResolutionsFileName)
+	if os.IsNotExist(err) {
+		return resolutions, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, &resolutions); err != nil {
+		return nil, err
+	}
+	return resolutions, nil
+}
+
+// Resolved returns the tag the version range of key was last resolved to, if any.
+func (s *Store) Resolved(key string) (string, bool, error) {
+	resolutions, err := s.readResolutions()
+	if err != nil {
+		return "", false, err
+	}
+	tag, ok := resolutions[key]
+	return tag, ok, nil
+}
+
+// Resolve records tag as the resolution of the version range of key.
+func (s *Store) Resolve(key, tag string) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// pick up any resolutions written by other processes before adding our own
+	resolutions, err := s.readResolutions()
+	if err != nil {
+		return err
+	}
+
+	resolutions[key] = tag
+
+	b, err := json.Marshal(resolutions)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(s.fs, ResolutionsFileName, b)
+}
@@ -0,0 +1,46 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package uses
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolutions(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store, err := NewStore(fs)
+	require.NoError(t, err)
+
+	key := "pkg:github/acme/tasks@%5E1.4"
+
+	_, ok, err := store.Resolved(key)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	require.NoError(t, store.Resolve(key, "v1.4.0"))
+	require.NoError(t, store.Resolve("pkg:github/acme/tasks@~2.0", "v2.0.1"))
+
+	tag, ok, err := store.Resolved(key)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "v1.4.0", tag)
+
+	require.NoError(t, store.Resolve(key, "v1.5.0"))
+
+	// resolutions are shared with other stores on the same directory
+	other, err := NewStore(fs)
+	require.NoError(t, err)
+
+	tag, ok, err = other.Resolved(key)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "v1.5.0", tag)
+
+	require.NoError(t, afero.WriteFile(fs, ResolutionsFileName, []byte("garbage"), 0644))
+	_, _, err = store.Resolved(key)
+	require.Error(t, err)
+}
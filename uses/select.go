@@ -4,64 +4,103 @@
 package uses
 
 import (
-	"fmt"
 	"net/url"
 
 	"github.com/package-url/packageurl-go"
 	"github.com/spf13/afero"
 )
 
-// SelectFetcher returns a Fetcher based on the URI scheme and previous scheme.
-func SelectFetcher(uri, previous *url.URL) (Fetcher, error) {
-	switch uri.Scheme {
-	case "http", "https":
-		return NewHTTPFetcher(), nil
-	case "pkg":
-		pURL, err := packageurl.FromString(uri.String())
+func init() {
+	RegisterScheme("file", func(uri *url.URL) (Fetcher, error) {
+		if IsArchive(uri) {
+			return NewArchiveFetcher(afero.NewOsFs()), nil
+		}
+		return NewLocalFetcher(afero.NewOsFs()), nil
+	})
+	for _, scheme := range []string{"http", "https"} {
+		RegisterScheme(scheme, func(uri *url.URL) (Fetcher, error) {
+			if IsArchive(uri) {
+				return NewArchiveFetcher(afero.NewOsFs()), nil
+			}
+			return NewHTTPFetcher(), nil
+		})
+	}
+	RegisterScheme("oci", func(_ *url.URL) (Fetcher, error) {
+		return NewOCIClient(), nil
+	})
+	for _, scheme := range []string{"git+file", "git+http", "git+https", "git+ssh"} {
+		RegisterScheme(scheme, func(_ *url.URL) (Fetcher, error) {
+			return NewGitFetcher(), nil
+		})
+	}
+	RegisterScheme("pkg", selectPackageFetcher)
+
+	RegisterType("github", func(uri *url.URL) (Fetcher, error) {
+		client, err := NewGitHubClient(baseQualifier(uri))
 		if err != nil {
 			return nil, err
 		}
-
-		switch pURL.Type {
-		case "github":
-			return NewGitHubClient(), nil
-		case "gitlab":
-			client, err := NewGitLabClient(pURL.Qualifiers.Map()["base"])
-			if err != nil {
-				return nil, err
-			}
-			return client, nil
-		default:
-			return nil, fmt.Errorf("unsupported type: %q", pURL.Type)
+		return client, nil
+	})
+	RegisterType("gitlab", func(uri *url.URL) (Fetcher, error) {
+		client, err := NewGitLabClient(baseQualifier(uri))
+		if err != nil {
+			return nil, err
 		}
-	case "file":
-		switch previous.Scheme {
-		case "file":
-			return NewLocalFetcher(afero.NewOsFs()), nil
-		case "http", "https":
-			return NewHTTPFetcher(), nil
-		case "pkg":
-			pURL, err := packageurl.FromString(previous.String())
-			if err != nil {
-				return nil, err
-			}
-			fmt.Println(previous)
-			switch pURL.Type {
-			case "github":
-				return NewGitHubClient(), nil
-			case "gitlab":
-				client, err := NewGitLabClient(pURL.Qualifiers.Map()["base"])
-				if err != nil {
-					return nil, err
-				}
-				return client, nil
-			default:
-				return nil, fmt.Errorf("unsupported type: %q", pURL.Type)
-			}
-		default:
-			return nil, fmt.Errorf("unsupported scheme: %q", previous.Scheme)
+		return client, nil
+	})
+	RegisterType("bitbucket", func(uri *url.URL) (Fetcher, error) {
+		client, err := NewBitbucketClient(baseQualifier(uri))
+		if err != nil {
+			return nil, err
 		}
-	default:
-		return nil, fmt.Errorf("unsupported scheme: %q", uri.Scheme)
+		return client, nil
+	})
+	RegisterType("gitea", func(uri *url.URL) (Fetcher, error) {
+		client, err := NewGiteaClient(baseQualifier(uri))
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	})
+	RegisterType("generic", func(_ *url.URL) (Fetcher, error) {
+		return NewGitFetcher(), nil
+	})
+}
+
+// SelectFetcher returns a Fetcher based on the URI scheme and previous scheme.
+//
+// Relative `file:` references are fetched the same way as the workflow that made them,
+// including references made from within a local archive.
+func SelectFetcher(uri, previous *url.URL) (Fetcher, error) {
+	if uri.Scheme == "file" && (previous.Scheme != "file" || IsArchive(previous)) {
+		uri = previous
+	}
+
+	factory, err := lookupScheme(uri.Scheme)
+	if err != nil {
+		return nil, err
+	}
+	return factory(uri)
+}
+
+// selectPackageFetcher returns a Fetcher based on the type of a package URL.
+func selectPackageFetcher(uri *url.URL) (Fetcher, error) {
+	pURL, err := packageurl.FromString(uri.String())
+	if err != nil {
+		return nil, err
 	}
+
+	factory, err := lookupType(pURL.Type)
+	if err != nil {
+		return nil, err
+	}
+	return factory(uri)
+}
+
+// baseQualifier returns the base qualifier of a package URL, dogsledding the error
+// since the URL has already been parsed by selectPackageFetcher.
+func baseQualifier(uri *url.URL) string {
+	pURL, _ := packageurl.FromString(uri.String())
+	return pURL.Qualifiers.Map()["base"]
 }
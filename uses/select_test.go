@@ -14,6 +14,9 @@ import (
 func TestSelectFetcher(t *testing.T) {
 	defaultPrev := "file:tmp/test"
 
+	gh, err := NewGitHubClient("")
+	require.NoError(t, err)
+
 	tests := []struct {
 		name        string
 		uri         string
@@ -39,7 +42,7 @@ func TestSelectFetcher(t *testing.T) {
 			name:        "file with pkg prev",
 			uri:         "file:tmp/test",
 			prev:        "pkg:github/noxsios/vai",
-			want:        NewGitHubClient(),
+			want:        gh,
 			expectedErr: "",
 		},
 		{
@@ -67,7 +70,7 @@ func TestSelectFetcher(t *testing.T) {
 			name:        "pkg-github",
 			uri:         "pkg:github/noxsios/vai",
 			prev:        defaultPrev,
-			want:        NewGitHubClient(),
+			want:        gh,
 			expectedErr: "",
 		},
 		{
@@ -133,6 +136,130 @@ func TestSelectFetcher(t *testing.T) {
 		})
 	}
 
+	t.Run("oci", func(t *testing.T) {
+		testCases := []struct {
+			name string
+			uri  string
+			prev string
+		}{
+			{
+				name: "default",
+				uri:  "oci://example.com/vai/tasks:v1#vai.yaml",
+				prev: defaultPrev,
+			},
+			{
+				name: "from previous",
+				uri:  defaultPrev,
+				prev: "oci://example.com/vai/tasks:v1#vai.yaml",
+			},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				uri, err := url.Parse(tc.uri)
+				require.NoError(t, err)
+
+				previous, err := url.Parse(tc.prev)
+				require.NoError(t, err)
+
+				got, err := SelectFetcher(uri, previous)
+				require.NoError(t, err)
+				require.IsType(t, &OCIClient{}, got)
+			})
+		}
+	})
+
+	t.Run("git", func(t *testing.T) {
+		testCases := []struct {
+			name string
+			uri  string
+			prev string
+		}{
+			{
+				name: "https",
+				uri:  "git+https://example.com/org/repo.git@v1#vai.yaml",
+				prev: defaultPrev,
+			},
+			{
+				name: "ssh",
+				uri:  "git+ssh://git@example.com/org/repo.git#vai.yaml",
+				prev: defaultPrev,
+			},
+			{
+				name: "generic",
+				uri:  "pkg:generic/repo@v1?vcs_url=git%2Bhttps://example.com/org/repo.git#vai.yaml",
+				prev: defaultPrev,
+			},
+			{
+				name: "from previous",
+				uri:  defaultPrev,
+				prev: "git+file:///srv/git/repo.git#vai.yaml",
+			},
+			{
+				name: "from previous generic",
+				uri:  defaultPrev,
+				prev: "pkg:generic/repo@v1?vcs_url=git%2Bhttps://example.com/org/repo.git#vai.yaml",
+			},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				uri, err := url.Parse(tc.uri)
+				require.NoError(t, err)
+
+				previous, err := url.Parse(tc.prev)
+				require.NoError(t, err)
+
+				got, err := SelectFetcher(uri, previous)
+				require.NoError(t, err)
+				require.IsType(t, &GitFetcher{}, got)
+			})
+		}
+	})
+
+	t.Run("archive", func(t *testing.T) {
+		testCases := []struct {
+			name string
+			uri  string
+			prev string
+		}{
+			{
+				name: "file",
+				uri:  "file:tasks.tar.gz#vai.yaml",
+				prev: defaultPrev,
+			},
+			{
+				name: "https",
+				uri:  "https://example.com/tasks.zip#vai.yaml",
+				prev: defaultPrev,
+			},
+			{
+				name: "from previous",
+				uri:  defaultPrev,
+				prev: "https://example.com/tasks.tgz#vai.yaml",
+			},
+			{
+				name: "from previous file",
+				uri:  defaultPrev,
+				prev: "file:tasks.tar.gz#vai.yaml",
+			},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				uri, err := url.Parse(tc.uri)
+				require.NoError(t, err)
+
+				previous, err := url.Parse(tc.prev)
+				require.NoError(t, err)
+
+				got, err := SelectFetcher(uri, previous)
+				require.NoError(t, err)
+				require.IsType(t, &ArchiveFetcher{}, got)
+			})
+		}
+	})
+
 	t.Run("pkg-gitlab", func(t *testing.T) {
 		testCases := []struct {
 			name string
@@ -190,4 +317,106 @@ func TestSelectFetcher(t *testing.T) {
 			})
 		}
 	})
+
+	t.Run("pkg-github", func(t *testing.T) {
+		uri, err := url.Parse("pkg:github/noxsios/vai?base=https://ghe.example.com/api/v3")
+		require.NoError(t, err)
+
+		previous, err := url.Parse(defaultPrev)
+		require.NoError(t, err)
+
+		got, err := SelectFetcher(uri, previous)
+		require.NoError(t, err)
+		require.IsType(t, &GitHubClient{}, got)
+		require.Equal(t, "https://ghe.example.com/api/v3/", got.(*GitHubClient).client.BaseURL.String())
+	})
+
+	t.Run("pkg-bitbucket", func(t *testing.T) {
+		testCases := []struct {
+			name   string
+			uri    string
+			prev   string
+			base   string
+			server bool
+		}{
+			{
+				name: "default",
+				uri:  "pkg:bitbucket/noxsios/vai",
+				prev: defaultPrev,
+				base: BitbucketCloudBase,
+			},
+			{
+				name: "default from previous",
+				uri:  defaultPrev,
+				prev: "pkg:bitbucket/noxsios/vai",
+				base: BitbucketCloudBase,
+			},
+			{
+				name:   "custom",
+				uri:    "pkg:bitbucket/noxsios/vai?base=https://bitbucket.example.com",
+				prev:   defaultPrev,
+				base:   "https://bitbucket.example.com",
+				server: true,
+			},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				uri, err := url.Parse(tc.uri)
+				require.NoError(t, err)
+
+				previous, err := url.Parse(tc.prev)
+				require.NoError(t, err)
+
+				got, err := SelectFetcher(uri, previous)
+				require.NoError(t, err)
+				require.IsType(t, &BitbucketClient{}, got)
+				require.Equal(t, tc.base, got.(*BitbucketClient).base.String())
+				require.Equal(t, tc.server, got.(*BitbucketClient).server)
+			})
+		}
+	})
+
+	t.Run("pkg-gitea", func(t *testing.T) {
+		testCases := []struct {
+			name string
+			uri  string
+			prev string
+			base string
+		}{
+			{
+				name: "default",
+				uri:  "pkg:gitea/noxsios/vai",
+				prev: defaultPrev,
+				base: "https://gitea.com",
+			},
+			{
+				name: "default from previous",
+				uri:  defaultPrev,
+				prev: "pkg:gitea/noxsios/vai",
+				base: "https://gitea.com",
+			},
+			{
+				name: "custom",
+				uri:  "pkg:gitea/noxsios/vai?base=https://codeberg.org",
+				prev: defaultPrev,
+				base: "https://codeberg.org",
+			},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				uri, err := url.Parse(tc.uri)
+				require.NoError(t, err)
+
+				previous, err := url.Parse(tc.prev)
+				require.NoError(t, err)
+
+				got, err := SelectFetcher(uri, previous)
+				require.NoError(t, err)
+				require.IsType(t, &GiteaClient{}, got)
+				require.Equal(t, tc.base, got.(*GiteaClient).base.String())
+			})
+		}
+	})
 }
@@ -13,8 +13,9 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"slices"
+	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/spf13/afero"
 )
@@ -44,25 +45,64 @@ type Descriptor struct {
 // IndexFileName is the name of the index file.
 const IndexFileName = "index.json"
 
-// CacheIndex is a list of files and their digests.
+// ErrDescriptorNotFound is returned when a descriptor is not present in the index.
+var ErrDescriptorNotFound = errors.New("descriptor not found")
+
+// LockFileName is the name of the file used to guard the index across processes.
+const LockFileName = "index.lock"
+
+var (
+	// lockTimeout is how long to wait for another process to release the lock.
+	lockTimeout = 30 * time.Second
+	// lockRetryInterval is how long to wait between attempts to acquire the lock.
+	lockRetryInterval = 10 * time.Millisecond
+)
+
+// processLocks guards stores whose filesystem is not backed by OS files, keyed by filesystem.
+//
+// Such filesystems (eg. in memory) cannot be shared across processes, so a mutex is enough.
+var processLocks sync.Map
+
+// IndexVersion is the current version of the index file format.
+//
+// Version 1 (implicit, no "version" field) stored a flat list of descriptors
+// with blobs at the root of the store.
+const IndexVersion = 2
+
+// BlobDir is the directory blobs are stored under, named after the digest algorithm.
+const BlobDir = "sha256"
+
+// BlobPath returns the location of a blob within the store.
+//
+// Blobs are sharded by the first two characters of their digest: sha256/ab/cdef...
+func BlobPath(hex string) string {
+	if len(hex) <= 2 {
+		return filepath.Join(BlobDir, hex)
+	}
+	return filepath.Join(BlobDir, hex[:2], hex[2:])
+}
+
+// CacheIndex is a set of files, keyed by their digests.
 type CacheIndex struct {
-	Content []Descriptor `json:"content"`
+	Version int                   `json:"version"`
+	Content map[string]Descriptor `json:"content"`
 }
 
 // NewCacheIndex creates a new cache index.
 func NewCacheIndex() *CacheIndex {
 	return &CacheIndex{
-		Content: []Descriptor{},
+		Version: IndexVersion,
+		Content: map[string]Descriptor{},
 	}
 }
 
 // Find returns the descriptor for a given key.
 func (c *CacheIndex) Find(desc Descriptor) (Descriptor, bool) {
-	i := slices.Index(c.Content, desc)
-	if i == -1 {
+	d, ok := c.Content[desc.Hex]
+	if !ok || d != desc {
 		return Descriptor{}, false
 	}
-	return c.Content[i], true
+	return d, true
 }
 
 // Add adds an entry to the index.
@@ -71,23 +111,25 @@ func (c *CacheIndex) Find(desc Descriptor) (Descriptor, bool) {
 //
 // If the desc does not exist in the index, it will be added.
 func (c *CacheIndex) Add(desc Descriptor) {
-	if _, ok := c.Find(desc); ok {
-		return
-	}
-	c.Content = append(c.Content, desc)
+	c.Content[desc.Hex] = desc
 }
 
 // Remove removes an entry from the index.
 func (c *CacheIndex) Remove(desc Descriptor) {
-	for i, d := range c.Content {
-		if d == desc {
-			c.Content = append(c.Content[:i], c.Content[i+1:]...)
-			return
-		}
+	if _, ok := c.Find(desc); ok {
+		delete(c.Content, desc.Hex)
 	}
 }
 
+// legacyCacheIndex is the version 1 index format.
+type legacyCacheIndex struct {
+	Content []Descriptor `json:"content"`
+}
+
 // Store is a cache for storing and retrieving remote workflows.
+//
+// The store is safe for concurrent use by multiple goroutines and multiple processes
+// sharing the same underlying directory.
 type Store struct {
 	index *CacheIndex
 
@@ -97,38 +139,223 @@ type Store struct {
 }
 
 // NewStore creates a new store at the given path.
+//
+// If the index does not exist it is created, and if it is in an older format it is migrated.
 func NewStore(fs afero.Fs) (*Store, error) {
-	index := NewCacheIndex()
+	s := &Store{
+		fs:    fs,
+		index: NewCacheIndex(),
+	}
+
+	if err := s.reload(); err == nil {
+		return s, nil
+	}
+
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 
-	_, err := fs.Stat(IndexFileName)
+	if err := s.migrate(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// migrate creates the index if it does not exist and upgrades older index formats in place.
+//
+// The caller must hold the lock.
+func (s *Store) migrate() error {
+	b, err := afero.ReadFile(s.fs, IndexFileName)
 	if os.IsNotExist(err) {
-		f, err := fs.Create(IndexFileName)
-		if err != nil {
-			return nil, err
+		s.index = NewCacheIndex()
+		return s.flush()
+	}
+	if err != nil {
+		return err
+	}
+
+	var header struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(b, &header); err != nil {
+		return err
+	}
+
+	switch header.Version {
+	case IndexVersion:
+		// another process migrated the index while we were waiting on the lock
+		return s.reload()
+	case 0:
+		var legacy legacyCacheIndex
+		if err := json.Unmarshal(b, &legacy); err != nil {
+			return err
+		}
+
+		index := NewCacheIndex()
+		for _, desc := range legacy.Content {
+			p := BlobPath(desc.Hex)
+			if err := s.fs.MkdirAll(filepath.Dir(p), 0755); err != nil {
+				return err
+			}
+			if err := s.fs.Rename(desc.Hex, p); err != nil {
+				// the blob is already gone, drop the entry so it gets fetched again
+				if os.IsNotExist(err) {
+					continue
+				}
+				return err
+			}
+			index.Add(desc)
 		}
-		defer f.Close()
 
-		_, err = f.WriteString("{}")
+		s.index = index
+		return s.flush()
+	default:
+		return fmt.Errorf("unsupported cache index version %d", header.Version)
+	}
+}
+
+// lock acquires an exclusive lock on the index that is respected across processes.
+//
+// On disk this is an advisory lock on LockFileName (flock, or LockFileEx on Windows), which the OS releases
+// if the process exits without unlocking, so a crashed process never leaves the cache locked.
+// The lock file itself is left in place, removing it would let two processes lock different files.
+//
+// The returned function releases the lock.
+func (s *Store) lock() (func(), error) {
+	f, err := s.fs.OpenFile(LockFileName, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	file, ok := osFile(f)
+	if !ok {
+		_ = f.Close()
+		return s.lockInProcess()
+	}
+
+	deadline := time.Now().Add(lockTimeout)
+
+	for {
+		locked, err := tryLockFile(file)
+		if errors.Is(err, errors.ErrUnsupported) {
+			_ = f.Close()
+			return s.lockInProcess()
+		}
 		if err != nil {
+			_ = f.Close()
 			return nil, err
 		}
-	} else if err != nil {
-		return nil, err
+		if locked {
+			return func() {
+				_ = unlockFile(file)
+				_ = f.Close()
+			}, nil
+		}
+
+		if time.Now().After(deadline) {
+			_ = f.Close()
+			return nil, fmt.Errorf("timed out waiting for cache lock %s", LockFileName)
+		}
+
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// lockInProcess acquires the lock for a filesystem that is not backed by OS files.
+func (s *Store) lockInProcess() (func(), error) {
+	v, _ := processLocks.LoadOrStore(s.fs, &sync.Mutex{})
+	mu := v.(*sync.Mutex)
+
+	deadline := time.Now().Add(lockTimeout)
+
+	for !mu.TryLock() {
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for cache lock %s", LockFileName)
+		}
+		time.Sleep(lockRetryInterval)
+	}
+
+	return mu.Unlock, nil
+}
+
+// osFile returns the OS file underlying f, if any.
+func osFile(f afero.File) (*os.File, bool) {
+	for {
+		switch v := f.(type) {
+		case *os.File:
+			return v, true
+		case *afero.BasePathFile:
+			f = v.File
+		default:
+			return nil, false
+		}
 	}
+}
 
-	b, err := afero.ReadFile(fs, IndexFileName)
+// reload replaces the in-memory index with the contents of the index file.
+func (s *Store) reload() error {
+	b, err := afero.ReadFile(s.fs, IndexFileName)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
+	index := &CacheIndex{}
 	if err := json.Unmarshal(b, index); err != nil {
-		return nil, err
+		return err
 	}
 
-	return &Store{
-		fs:    fs,
-		index: index,
-	}, nil
+	if index.Version != IndexVersion {
+		return fmt.Errorf("unsupported cache index version %d", index.Version)
+	}
+
+	s.index = index
+	return nil
+}
+
+// flush writes the in-memory index to the index file.
+func (s *Store) flush() error {
+	b, err := json.Marshal(s.index)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(s.fs, IndexFileName, b)
+}
+
+// writeFileAtomic writes data to a temporary file next to name and then renames it into place,
+// so readers never observe a partially written file.
+func writeFileAtomic(fs afero.Fs, name string, data []byte) error {
+	f, err := afero.TempFile(fs, filepath.Dir(name), "."+filepath.Base(name)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		_ = fs.Remove(tmp)
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		_ = fs.Remove(tmp)
+		return err
+	}
+
+	if err := fs.Chmod(tmp, 0644); err != nil {
+		_ = fs.Remove(tmp)
+		return err
+	}
+
+	if err := fs.Rename(tmp, name); err != nil {
+		_ = fs.Remove(tmp)
+		return err
+	}
+
+	return nil
 }
 
 // Fetch retrieves a workflow from the store
@@ -138,10 +365,10 @@ func (s *Store) Fetch(desc Descriptor) (io.ReadCloser, error) {
 
 	desc, ok := s.index.Find(desc)
 	if !ok {
-		return nil, fmt.Errorf("descriptor not found")
+		return nil, ErrDescriptorNotFound
 	}
 
-	f, err := s.fs.Open(desc.Hex)
+	f, err := s.fs.Open(BlobPath(desc.Hex))
 	if err != nil {
 		return nil, err
 	}
@@ -166,21 +393,33 @@ func (s *Store) Store(r io.Reader) error {
 
 	hex := fmt.Sprintf("%x", hasher.Sum(nil))
 
-	if err := afero.WriteFile(s.fs, hex, buf.Bytes(), 0644); err != nil {
+	p := BlobPath(hex)
+	if err := s.fs.MkdirAll(filepath.Dir(p), 0755); err != nil {
 		return err
 	}
 
-	s.index.Add(Descriptor{
-		Size: int64(buf.Len()),
-		Hex:  hex,
-	})
+	// blobs are content addressed, so concurrent writers of the same blob write identical bytes
+	if err := writeFileAtomic(s.fs, p, buf.Bytes()); err != nil {
+		return err
+	}
 
-	b, err := json.Marshal(s.index)
+	unlock, err := s.lock()
 	if err != nil {
 		return err
 	}
+	defer unlock()
+
+	// pick up any entries written by other processes before adding our own
+	if err := s.reload(); err != nil {
+		return err
+	}
 
-	return afero.WriteFile(s.fs, IndexFileName, b, 0644)
+	s.index.Add(Descriptor{
+		Size: int64(buf.Len()),
+		Hex:  hex,
+	})
+
+	return s.flush()
 }
 
 // Delete a workflow from the store.
@@ -188,39 +427,67 @@ func (s *Store) Delete(desc Descriptor) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	desc, ok := s.index.Find(desc)
-	if !ok {
-		return fmt.Errorf("descriptor not found")
+	desc, err := s.evict(desc)
+	if err != nil {
+		return err
 	}
 
-	s.index.Remove(desc)
+	if err := s.fs.Remove(BlobPath(desc.Hex)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
 
-	b, err := json.Marshal(s.index)
+	return nil
+}
+
+// evict removes a descriptor from the index without touching its file.
+//
+// The caller must hold s.mu.
+func (s *Store) evict(desc Descriptor) (Descriptor, error) {
+	unlock, err := s.lock()
 	if err != nil {
-		return err
+		return Descriptor{}, err
 	}
+	defer unlock()
 
-	if err := afero.WriteFile(s.fs, IndexFileName, b, 0644); err != nil {
-		return err
+	if err := s.reload(); err != nil {
+		return Descriptor{}, err
 	}
 
-	return s.fs.Remove(desc.Hex)
+	desc, ok := s.index.Find(desc)
+	if !ok {
+		return Descriptor{}, ErrDescriptorNotFound
+	}
+
+	s.index.Remove(desc)
+
+	return desc, s.flush()
 }
 
 // Exists checks if a workflow exists in the store.
+//
+// Only the index and the size of the file on disk are consulted, use Verify to check the file's digest.
+//
+// If the index references a file that no longer exists (eg. removed by hand or by a crashed process),
+// the entry is dropped from the index and Exists reports false so the workflow can be fetched again.
 func (s *Store) Exists(desc Descriptor) (bool, error) {
 	s.mu.RLock()
-	defer s.mu.RUnlock()
-
 	desc, ok := s.index.Find(desc)
+	s.mu.RUnlock()
+
 	if !ok {
 		return false, nil
 	}
 
-	fi, err := s.fs.Stat(desc.Hex)
+	fi, err := s.fs.Stat(BlobPath(desc.Hex))
 	if err != nil {
 		if os.IsNotExist(err) {
-			return false, fmt.Errorf("descriptor exists in index, but no corresponding file was found, possible cache corruption: %s", desc.Hex)
+			s.mu.Lock()
+			defer s.mu.Unlock()
+
+			if _, err := s.evict(desc); err != nil && !errors.Is(err, ErrDescriptorNotFound) {
+				return false, fmt.Errorf("descriptor exists in index, but no corresponding file was found, failed to recover: %w", err)
+			}
+			return false, nil
 		}
 		return false, err
 	}
@@ -229,21 +496,31 @@ func (s *Store) Exists(desc Descriptor) (bool, error) {
 		return false, fmt.Errorf("size mismatch, expected %d, got %d", desc.Size, fi.Size())
 	}
 
+	return true, nil
+}
+
+// Verify re-hashes a stored workflow and checks it against its descriptor.
+func (s *Store) Verify(desc Descriptor) error {
+	rc, err := s.Fetch(desc)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
 	hasher := sha256.New()
 
-	f, err := s.fs.Open(desc.Hex)
+	n, err := io.Copy(hasher, rc)
 	if err != nil {
-		return false, err
+		return err
 	}
-	defer f.Close()
 
-	if _, err := io.Copy(hasher, f); err != nil {
-		return false, err
+	if n != desc.Size {
+		return fmt.Errorf("size mismatch, expected %d, got %d", desc.Size, n)
 	}
 
 	if fmt.Sprintf("%x", hasher.Sum(nil)) != desc.Hex {
-		return false, errors.New("hash mismatch")
+		return errors.New("hash mismatch")
 	}
 
-	return true, nil
+	return nil
 }
@@ -8,7 +8,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/require"
@@ -31,14 +35,16 @@ func TestCacheIndex(t *testing.T) {
 	index.Add(foo)
 
 	require.Equal(t, &CacheIndex{
-		Content: []Descriptor{foo},
+		Version: IndexVersion,
+		Content: map[string]Descriptor{"foo": foo},
 	}, index)
 
 	// add again, no change
 	index.Add(foo)
 
 	require.Equal(t, &CacheIndex{
-		Content: []Descriptor{foo},
+		Version: IndexVersion,
+		Content: map[string]Descriptor{"foo": foo},
 	}, index)
 
 	// found
@@ -51,6 +57,11 @@ func TestCacheIndex(t *testing.T) {
 	require.Equal(t, Descriptor{}, val)
 	require.False(t, ok)
 
+	// same digest, different size is not a match
+	val, ok = index.Find(Descriptor{Hex: "foo", Size: 4})
+	require.Equal(t, Descriptor{}, val)
+	require.False(t, ok)
+
 	// remove
 	index.Remove(foo)
 	val, ok = index.Find(foo)
@@ -63,6 +74,11 @@ func TestCacheIndex(t *testing.T) {
 	require.False(t, ok)
 }
 
+func TestBlobPath(t *testing.T) {
+	require.Equal(t, filepath.Join("sha256", "ca", "978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb"), BlobPath(shaMap["a"]))
+	require.Equal(t, filepath.Join("sha256", "ab"), BlobPath("ab"))
+}
+
 var shaMap = map[string]string{
 	"a": "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb",
 	"b": "3e23e8160039594a33894f6564e1b1348bbd7a0088d42c4acb73eeaed59c009d",
@@ -77,7 +93,7 @@ func TestStore(t *testing.T) {
 	// store initializes with empty index
 	b, err := afero.ReadFile(fs, IndexFileName)
 	require.NoError(t, err)
-	require.JSONEq(t, "{}", string(b))
+	require.JSONEq(t, `{"version": 2, "content": {}}`, string(b))
 
 	// new additions cause no errors
 	for k := range shaMap {
@@ -90,7 +106,7 @@ func TestStore(t *testing.T) {
 	var index CacheIndex
 	err = json.Unmarshal(b, &index)
 	require.NoError(t, err)
-	require.ElementsMatch(t, index.Content, store.index.Content)
+	require.Equal(t, index.Content, store.index.Content)
 
 	// all keys exist at the correct sha
 	for _, v := range shaMap {
@@ -113,9 +129,10 @@ func TestStore(t *testing.T) {
 	}), "descriptor not found")
 	b, err = afero.ReadFile(fs, IndexFileName)
 	require.NoError(t, err)
+	index = CacheIndex{}
 	err = json.Unmarshal(b, &index)
 	require.NoError(t, err)
-	require.ElementsMatch(t, index.Content, store.index.Content)
+	require.Equal(t, index.Content, store.index.Content)
 	ok, err := store.Exists(Descriptor{
 		Hex:  shaMap["a"],
 		Size: 1,
@@ -146,17 +163,33 @@ func TestStore(t *testing.T) {
 	require.NoError(t, err)
 
 	// cause a mismatch between index and fs, causing cache corruption
-	err = fs.Remove(shaMap["b"])
+	err = fs.Remove(BlobPath(shaMap["b"]))
 	require.NoError(t, err)
 	ok, err = store.Exists(Descriptor{
 		Hex:  shaMap["b"],
 		Size: 1,
 	})
+	require.NoError(t, err)
+	require.False(t, ok)
+	// the stale entry is dropped from the index so the workflow can be fetched again
+	_, ok = store.index.Find(Descriptor{
+		Hex:  shaMap["b"],
+		Size: 1,
+	})
 	require.False(t, ok)
-	require.EqualError(t, err, fmt.Sprintf("descriptor exists in index, but no corresponding file was found, possible cache corruption: %s", shaMap["b"]))
+	b, err = afero.ReadFile(fs, IndexFileName)
+	require.NoError(t, err)
+	require.NotContains(t, string(b), shaMap["b"])
+	require.NoError(t, store.Store(bytes.NewReader([]byte("b"))))
+	ok, err = store.Exists(Descriptor{
+		Hex:  shaMap["b"],
+		Size: 1,
+	})
+	require.NoError(t, err)
+	require.True(t, ok)
 
 	// change the contents of a file, causing size mismatch
-	require.NoError(t, afero.WriteFile(fs, shaMap["c"], []byte("foo"), 0644))
+	require.NoError(t, afero.WriteFile(fs, BlobPath(shaMap["c"]), []byte("foo"), 0644))
 	ok, err = store.Exists(Descriptor{
 		Hex:  shaMap["c"],
 		Size: 1,
@@ -164,12 +197,153 @@ func TestStore(t *testing.T) {
 	require.False(t, ok)
 	require.EqualError(t, err, fmt.Sprintf("size mismatch, expected %d, got %d", 1, 3))
 
-	// change the contents of a file, causing hash mismatch
-	require.NoError(t, afero.WriteFile(fs, shaMap["c"], []byte("f"), 0644))
+	// change the contents of a file, hashes are only checked on demand
+	require.NoError(t, afero.WriteFile(fs, BlobPath(shaMap["c"]), []byte("f"), 0644))
 	ok, err = store.Exists(Descriptor{
 		Hex:  shaMap["c"],
 		Size: 1,
 	})
-	require.False(t, ok)
-	require.EqualError(t, err, "hash mismatch")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.EqualError(t, store.Verify(Descriptor{
+		Hex:  shaMap["c"],
+		Size: 1,
+	}), "hash mismatch")
+	require.NoError(t, store.Verify(Descriptor{
+		Hex:  shaMap["b"],
+		Size: 1,
+	}))
+	require.EqualError(t, store.Verify(Descriptor{
+		Hex:  shaMap["a"],
+		Size: 1,
+	}), "descriptor not found")
+}
+
+func TestStoreMigrate(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	// version 1 layout: flat list of descriptors, blobs at the root
+	legacy := `{"content":[{"Size":1,"Hex":"` + shaMap["a"] + `"},{"Size":1,"Hex":"` + shaMap["b"] + `"}]}`
+	require.NoError(t, afero.WriteFile(fs, IndexFileName, []byte(legacy), 0644))
+	require.NoError(t, afero.WriteFile(fs, shaMap["a"], []byte("a"), 0644))
+	// shaMap["b"] is missing on disk and should be dropped
+
+	store, err := NewStore(fs)
+	require.NoError(t, err)
+
+	a := Descriptor{Hex: shaMap["a"], Size: 1}
+	require.Equal(t, map[string]Descriptor{shaMap["a"]: a}, store.index.Content)
+
+	_, err = fs.Stat(shaMap["a"])
+	require.True(t, os.IsNotExist(err))
+	b, err := afero.ReadFile(fs, BlobPath(shaMap["a"]))
+	require.NoError(t, err)
+	require.Equal(t, "a", string(b))
+	require.NoError(t, store.Verify(a))
+
+	b, err = afero.ReadFile(fs, IndexFileName)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"version": 2, "content": {"`+shaMap["a"]+`": {"Size": 1, "Hex": "`+shaMap["a"]+`"}}}`, string(b))
+
+	// an empty version 1 index is upgraded as well
+	fs = afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, IndexFileName, []byte("{}"), 0644))
+	store, err = NewStore(fs)
+	require.NoError(t, err)
+	require.Empty(t, store.index.Content)
+
+	// unknown versions are rejected
+	fs = afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, IndexFileName, []byte(`{"version": 99}`), 0644))
+	_, err = NewStore(fs)
+	require.EqualError(t, err, "unsupported cache index version 99")
+}
+
+func TestStoreLock(t *testing.T) {
+	timeout := lockTimeout
+	t.Cleanup(func() {
+		lockTimeout = timeout
+	})
+	lockTimeout = 100 * time.Millisecond
+
+	for name, fs := range map[string]afero.Fs{
+		"memory": afero.NewMemMapFs(),
+		"disk":   afero.NewBasePathFs(afero.NewOsFs(), t.TempDir()),
+	} {
+		t.Run(name, func(t *testing.T) {
+			store, err := NewStore(fs)
+			require.NoError(t, err)
+
+			// another store on the same directory simulates another process
+			other, err := NewStore(fs)
+			require.NoError(t, err)
+
+			// a lock held by another process blocks writes
+			unlock, err := other.lock()
+			require.NoError(t, err)
+			require.EqualError(t, store.Store(bytes.NewReader([]byte("a"))), "timed out waiting for cache lock "+LockFileName)
+
+			// once released, writes go through
+			unlock()
+			require.NoError(t, store.Store(bytes.NewReader([]byte("a"))))
+
+			// a lock file left behind by a crashed process does not block anything
+			require.NoError(t, afero.WriteFile(fs, LockFileName, []byte("1"), 0644))
+			require.NoError(t, store.Store(bytes.NewReader([]byte("b"))))
+
+			// no temporary files are left behind
+			names := []string{}
+			require.NoError(t, afero.Walk(fs, ".", func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if !info.IsDir() {
+					names = append(names, path)
+				}
+				return nil
+			}))
+			require.Subset(t, []string{IndexFileName, LockFileName, BlobPath(shaMap["a"]), BlobPath(shaMap["b"])}, names)
+			require.Contains(t, names, BlobPath(shaMap["b"]))
+		})
+	}
+}
+
+func TestStoreMultiProcess(t *testing.T) {
+	// each store simulates a separate process sharing the same cache directory
+	fs := afero.NewBasePathFs(afero.NewOsFs(), t.TempDir())
+
+	n := 10
+	stores := make([]*Store, n)
+	for i := range stores {
+		store, err := NewStore(fs)
+		require.NoError(t, err)
+		stores[i] = store
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i, store := range stores {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- store.Store(bytes.NewReader([]byte(fmt.Sprintf("content-%d", i))))
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	// no writer clobbered another's entry
+	store, err := NewStore(fs)
+	require.NoError(t, err)
+	require.Len(t, store.index.Content, n)
+
+	for _, desc := range store.index.Content {
+		ok, err := store.Exists(desc)
+		require.NoError(t, err)
+		require.True(t, ok)
+	}
 }
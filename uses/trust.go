@@ -0,0 +1,66 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package uses
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/spf13/afero"
+)
+
+// TrustFileName is the name of the file recording the content last run from each remote location.
+const TrustFileName = "trust.json"
+
+// readTrust reads the trust records, a missing file has no records.
+func (s *Store) readTrust() (map[string]Descriptor, error) {
+	records := make(map[string]Descriptor)
+
+	b, err := afero.ReadFile(s.fs, TrustFileName)
+	if os.IsNotExist(err) {
+		return records, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// Trusted returns the descriptor of the content last run from location, if any.
+func (s *Store) Trusted(location string) (Descriptor, bool, error) {
+	records, err := s.readTrust()
+	if err != nil {
+		return Descriptor{}, false, err
+	}
+	desc, ok := records[location]
+	return desc, ok, nil
+}
+
+// Trust records desc as the content last run from location.
+func (s *Store) Trust(location string, desc Descriptor) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// pick up any records written by other processes before adding our own
+	records, err := s.readTrust()
+	if err != nil {
+		return err
+	}
+
+	records[location] = desc
+
+	b, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(s.fs, TrustFileName, b)
+}
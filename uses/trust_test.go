@@ -0,0 +1,48 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package uses
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrust(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store, err := NewStore(fs)
+	require.NoError(t, err)
+
+	location := "pkg:github/acme/tasks@main#vai.yaml"
+	foo := Descriptor{Hex: "foo", Size: 3}
+	bar := Descriptor{Hex: "bar", Size: 3}
+
+	_, ok, err := store.Trusted(location)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	require.NoError(t, store.Trust(location, foo))
+	require.NoError(t, store.Trust("https://example.com/vai.yaml", bar))
+
+	desc, ok, err := store.Trusted(location)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, foo, desc)
+
+	require.NoError(t, store.Trust(location, bar))
+
+	// records are shared with other stores on the same directory
+	other, err := NewStore(fs)
+	require.NoError(t, err)
+
+	desc, ok, err = other.Trusted(location)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, bar, desc)
+
+	require.NoError(t, afero.WriteFile(fs, TrustFileName, []byte("garbage"), 0644))
+	_, _, err = store.Trusted(location)
+	require.Error(t, err)
+}
@@ -0,0 +1,68 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package uses
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// TagLister lists the tags of the repository a reference points to.
+type TagLister interface {
+	Tags(context.Context, string) ([]string, error)
+}
+
+// IsVersionRange reports whether a `pkg:` version is a semver range (eg. ^1.4, ~2.0, >=1.2 <2)
+// rather than a literal ref.
+//
+// Ranges must start with an operator so that branch names like 1.x are still treated literally.
+func IsVersionRange(version string) bool {
+	if version == "" || !strings.ContainsAny(version[:1], "^~<>=!*") {
+		return false
+	}
+	_, err := semver.NewConstraint(version)
+	return err == nil
+}
+
+// IsFullVersion reports whether tag is a full MAJOR.MINOR.PATCH semver version, optionally prefixed with v.
+//
+// Floating tags like v1 or v1.4, which are moved to each new release, are not.
+func IsFullVersion(tag string) bool {
+	_, err := semver.StrictNewVersion(strings.TrimPrefix(tag, "v"))
+	return err == nil
+}
+
+// HighestMatch returns the tag with the highest semver version that satisfies constraint.
+//
+// Tags that are not valid semver versions are ignored. The tag is returned as written, eg. v1.4.2.
+func HighestMatch(constraint string, tags []string) (string, error) {
+	c, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return "", err
+	}
+
+	var best *semver.Version
+	var match string
+	for _, tag := range tags {
+		v, err := semver.NewVersion(tag)
+		if err != nil {
+			continue
+		}
+		if !c.Check(v) {
+			continue
+		}
+		if best == nil || v.GreaterThan(best) {
+			best, match = v, tag
+		}
+	}
+
+	if best == nil {
+		return "", fmt.Errorf("no tags match %q", constraint)
+	}
+
+	return match, nil
+}
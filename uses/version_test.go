@@ -0,0 +1,87 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package uses
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsVersionRange(t *testing.T) {
+	testCases := map[string]bool{
+		"":             false,
+		"main":         false,
+		"v1.4.2":       false,
+		"1.4.2":        false,
+		"1.x":          false,
+		"release/1.x":  false,
+		"^1.4":         true,
+		"~2.0":         true,
+		">=1.2 <2":     true,
+		">=1.2, <2":    true,
+		"=1.4.2":       true,
+		"*":            true,
+		"^not-semver":  false,
+		"~feature-foo": false,
+	}
+
+	for version, expected := range testCases {
+		t.Run(version, func(t *testing.T) {
+			require.Equal(t, expected, IsVersionRange(version))
+		})
+	}
+}
+
+func TestIsFullVersion(t *testing.T) {
+	testCases := map[string]bool{
+		"v1.4.2":       true,
+		"1.4.2":        true,
+		"v2.1.0-rc.1":  true,
+		"v1.4.2+build": true,
+		"v1":           false,
+		"v1.4":         false,
+		"1":            false,
+		"main":         false,
+		"vv1.4.2":      false,
+		"^1.4":         false,
+	}
+
+	for tag, expected := range testCases {
+		t.Run(tag, func(t *testing.T) {
+			require.Equal(t, expected, IsFullVersion(tag))
+		})
+	}
+}
+
+func TestHighestMatch(t *testing.T) {
+	tags := []string{"v1.3.0", "v1.4.0", "v1.4.2", "v1.10.0", "v2.0.0", "v2.0.1", "v2.1.0-rc.1", "nightly", "1.5.0"}
+
+	testCases := []struct {
+		constraint  string
+		expected    string
+		expectedErr string
+	}{
+		{constraint: "^1.4", expected: "v1.10.0"},
+		{constraint: "~1.4", expected: "v1.4.2"},
+		{constraint: "~2.0", expected: "v2.0.1"},
+		{constraint: ">=1.4 <1.6", expected: "1.5.0"},
+		{constraint: "^2", expected: "v2.0.1"},
+		{constraint: "^2.1.0-rc", expected: "v2.1.0-rc.1"},
+		{constraint: "*", expected: "v2.0.1"},
+		{constraint: "^3", expectedErr: `no tags match "^3"`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.constraint, func(t *testing.T) {
+			tag, err := HighestMatch(tc.constraint, tags)
+			if tc.expectedErr != "" {
+				require.EqualError(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, tag)
+		})
+	}
+}
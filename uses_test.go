@@ -4,12 +4,18 @@
 package vai
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/sha256"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/goccy/go-yaml"
@@ -95,8 +101,8 @@ func TestExecuteUses(t *testing.T) {
 	err = ExecuteUses(ctx, store, "ssh:not-supported", with, "file:test", false)
 	require.EqualError(t, err, `unsupported scheme: "ssh"`)
 
-	err = ExecuteUses(ctx, store, "pkg:bitbucket/owner/repo", with, "file:test", false)
-	require.EqualError(t, err, `unsupported type: "bitbucket"`)
+	err = ExecuteUses(ctx, store, "pkg:sourcehut/owner/repo", with, "file:test", false)
+	require.EqualError(t, err, `unsupported type: "sourcehut"`)
 
 	err = ExecuteUses(ctx, store, "file:..?task=hello-world", with, "pkg:", false)
 	require.EqualError(t, err, `purl is missing type or name`)
@@ -111,22 +117,22 @@ func TestExecuteUses(t *testing.T) {
 	err = ExecuteUses(ctx, store, server.URL+"/foo.yaml", with, "file:test", false)
 	require.NoError(t, err)
 
-	files, err := afero.ReadDir(fs, "/")
-	require.NoError(t, err)
-
-	for _, f := range files {
-		if f.Name() == uses.IndexFileName {
-			continue
+	err = afero.Walk(fs, uses.BlobDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
 		}
 
+		hex := filepath.Base(filepath.Dir(path)) + info.Name()
+		require.Equal(t, uses.BlobPath(hex), path)
+
 		hasher := sha256.New()
-		b, err := afero.ReadFile(fs, f.Name())
+		b, err := afero.ReadFile(fs, path)
 		require.NoError(t, err)
 		_, err = hasher.Write(b)
 		require.NoError(t, err)
-		require.Equal(t, f.Name(), fmt.Sprintf("%x", hasher.Sum(nil)))
+		require.Equal(t, hex, fmt.Sprintf("%x", hasher.Sum(nil)))
 
-		desc := uses.Descriptor{Hex: f.Name(), Size: f.Size()}
+		desc := uses.Descriptor{Hex: hex, Size: info.Size()}
 
 		rc, err := store.Fetch(desc)
 		require.NoError(t, err)
@@ -135,5 +141,225 @@ func TestExecuteUses(t *testing.T) {
 		b2, err := io.ReadAll(rc)
 		require.NoError(t, err)
 		require.Equal(t, b, b2)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestResolveVersion(t *testing.T) {
+	ctx := context.Background()
+	store, err := uses.NewStore(afero.NewMemMapFs())
+	require.NoError(t, err)
+
+	refs := uses.RefIndex{}
+	for _, version := range []string{"v1.3.0", "v1.4.2", "v1.10.0", "v2.0.0", "main"} {
+		wf := Workflow{"default": {Step{Run: "echo " + version}}}
+		b, err := yaml.Marshal(wf)
+		require.NoError(t, err)
+		require.NoError(t, store.Store(bytes.NewReader(b)))
+
+		refs["pkg:github/noxsios/vai@"+version+"#vai.yaml"] = uses.Descriptor{
+			Size: int64(len(b)),
+			Hex:  fmt.Sprintf("%x", sha256.Sum256(b)),
+		}
+	}
+
+	ctx = WithRefIndex(ctx, refs)
+
+	testCases := []struct {
+		uses        string
+		location    string
+		origin      string
+		expectedErr string
+	}{
+		{
+			uses:     "pkg:github/noxsios/vai@^1.4",
+			location: "pkg:github/noxsios/vai@v1.10.0#vai.yaml",
+			origin:   "pkg:github/noxsios/vai@v1.10.0",
+		},
+		{
+			uses:     "pkg:github/noxsios/vai@~1.4#vai.yaml",
+			location: "pkg:github/noxsios/vai@v1.4.2#vai.yaml",
+			origin:   "pkg:github/noxsios/vai@v1.4.2#vai.yaml",
+		},
+		{
+			uses:     "pkg:github/noxsios/vai@%3E%3D1.0%20%3C1.4#vai.yaml",
+			location: "pkg:github/noxsios/vai@v1.3.0#vai.yaml",
+			origin:   "pkg:github/noxsios/vai@v1.3.0#vai.yaml",
+		},
+		{
+			uses:     "pkg:github/noxsios/vai@main#vai.yaml",
+			location: "pkg:github/noxsios/vai@main#vai.yaml",
+			origin:   "pkg:github/noxsios/vai@main#vai.yaml",
+		},
+		{
+			uses:     "file:vai.yaml",
+			location: "file:vai.yaml",
+			origin:   "file:vai.yaml",
+		},
+		{
+			uses:        "pkg:github/noxsios/vai@^3#vai.yaml",
+			expectedErr: `pkg:github/noxsios/vai@%5E3#vai.yaml: no tags match "^3"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.uses, func(t *testing.T) {
+			ref, err := resolve(ctx, tc.uses, "file:vai.yaml")
+			require.NoError(t, err)
+
+			ref, err = resolveVersion(ctx, store, ref)
+			if tc.expectedErr != "" {
+				require.EqualError(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.location, ref.location)
+			require.Equal(t, tc.origin, ref.origin)
+		})
+	}
+
+	require.NoError(t, ExecuteUses(ctx, store, "pkg:github/noxsios/vai@~2.0", With{}, "file:vai.yaml", false))
+
+	// resolutions are recorded, and reused even once a newer tag matches
+	tag, ok, err := store.Resolved("pkg:github/noxsios/vai@%5E1.4")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "v1.10.0", tag)
+
+	b, err := yaml.Marshal(Workflow{"default": {Step{Run: "echo v1.11.0"}}})
+	require.NoError(t, err)
+	require.NoError(t, store.Store(bytes.NewReader(b)))
+	refs["pkg:github/noxsios/vai@v1.11.0#vai.yaml"] = uses.Descriptor{
+		Size: int64(len(b)),
+		Hex:  fmt.Sprintf("%x", sha256.Sum256(b)),
+	}
+	ctx = WithRefIndex(context.Background(), refs)
+
+	ref, err := resolve(ctx, "pkg:github/noxsios/vai@^1.4#other.yaml", "file:vai.yaml")
+	require.NoError(t, err)
+	ref, err = resolveVersion(ctx, store, ref)
+	require.NoError(t, err)
+	require.Equal(t, "pkg:github/noxsios/vai@v1.10.0#other.yaml", ref.location)
+
+	// until they are explicitly updated
+	ref, err = resolve(ctx, "pkg:github/noxsios/vai@^1.4#other.yaml", "file:vai.yaml")
+	require.NoError(t, err)
+	ref, err = resolveVersion(WithUpdateVersions(ctx), store, ref)
+	require.NoError(t, err)
+	require.Equal(t, "pkg:github/noxsios/vai@v1.11.0#other.yaml", ref.location)
+
+	ref, err = resolve(ctx, "pkg:github/noxsios/vai@^1.4#vai.yaml", "file:vai.yaml")
+	require.NoError(t, err)
+	ref, err = resolveVersion(ctx, store, ref)
+	require.NoError(t, err)
+	require.Equal(t, "pkg:github/noxsios/vai@v1.11.0#vai.yaml", ref.location)
+
+	// fetchers that cannot list tags do not support ranges
+	_, err = resolveVersion(context.Background(), store, reference{
+		uri:      &url.URL{Scheme: "https", Host: "example.com"},
+		location: "pkg:github/noxsios/vai@^1#vai.yaml",
+		origin:   "pkg:github/noxsios/vai@^1#vai.yaml",
+	})
+	require.EqualError(t, err, "*uses.HTTPFetcher does not support version ranges: pkg:github/noxsios/vai@^1#vai.yaml")
+}
+
+func TestWithoutTask(t *testing.T) {
+	testCases := map[string]string{
+		"https://example.com/vai.yaml?task=echo":                       "https://example.com/vai.yaml",
+		"pkg:github/acme/tasks@v1?base=https://ghe&task=echo#vai.yaml": "pkg:github/acme/tasks@v1?base=https%3A%2F%2Fghe#vai.yaml",
+		"oci://ghcr.io/acme/tasks:v1#vai.yaml":                         "oci://ghcr.io/acme/tasks:v1#vai.yaml",
+	}
+
+	for location, expected := range testCases {
+		got, err := withoutTask(location)
+		require.NoError(t, err)
+		require.Equal(t, expected, got)
+	}
+}
+
+func TestArchiveUses(t *testing.T) {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for name, content := range map[string]string{
+		"ci/vai.yaml":      "build:\n  - uses: file:lib/echo.yaml?task=echo\n",
+		"ci/lib/echo.yaml": "echo:\n  - uses: file:../../vai.yaml\n",
+		"vai.yaml":         "default:\n  - run: echo 'from the archive'\n",
+	} {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+
+	var paths []string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if r.URL.Path != "/releases/tasks-v1.tar.gz" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(buf.Bytes())
 	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	store, err := uses.NewStore(afero.NewMemMapFs())
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	ref, err := resolve(ctx, "file:lib/echo.yaml?task=echo", server.URL+"/releases/tasks-v1.tar.gz#ci/vai.yaml")
+	require.NoError(t, err)
+	require.Equal(t, server.URL+"/releases/tasks-v1.tar.gz#ci/lib/echo.yaml", ref.location)
+
+	// relative references within the archive are read from the same archive, which is downloaded once per run
+	require.NoError(t, ExecuteUses(uses.WithArchiveCache(ctx), store, server.URL+"/releases/tasks-v1.tar.gz?task=build#ci/vai.yaml", With{}, "file:vai.yaml", false))
+	require.Equal(t, []string{"/releases/tasks-v1.tar.gz"}, paths)
+
+	// the file within the archive defaults to vai.yaml
+	require.NoError(t, ExecuteUses(ctx, store, server.URL+"/releases/tasks-v1.tar.gz", With{}, "file:vai.yaml", false))
+}
+
+func TestOpen(t *testing.T) {
+	content := "default:\n  - uses: file:lib/vai.yaml\n"
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/tasks/vai.yaml" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(content))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	store, err := uses.NewStore(afero.NewMemMapFs())
+	require.NoError(t, err)
+
+	var asked []string
+	ctx := WithConfirm(context.Background(), func(location, _ string) (bool, error) {
+		asked = append(asked, location)
+		return false, nil
+	})
+
+	wf, origin, err := Open(ctx, store, server.URL+"/tasks/vai.yaml", false)
+	require.NoError(t, err)
+	require.Equal(t, server.URL+"/tasks/vai.yaml", origin)
+	require.Equal(t, Workflow{"default": {{Uses: "file:lib/vai.yaml"}}}, wf)
+
+	// changes are reviewed, unless nothing is run
+	content = "default:\n  - run: echo 'changed'\n"
+	_, _, err = Open(ctx, store, server.URL+"/tasks/vai.yaml", true)
+	require.NoError(t, err)
+	require.Empty(t, asked)
+
+	_, _, err = Open(ctx, store, server.URL+"/tasks/vai.yaml", false)
+	require.EqualError(t, err, server.URL+"/tasks/vai.yaml changed since it was last run and the change was not trusted")
+	require.Len(t, asked, 1)
+
+	_, _, err = Open(ctx, store, server.URL+"/dne.yaml", false)
+	require.Error(t, err)
 }
@@ -15,6 +15,7 @@ import (
 	"sync"
 
 	"github.com/goccy/go-yaml"
+	"github.com/noxsios/vai/uses"
 	"github.com/xeipuuv/gojsonschema"
 )
 
@@ -49,6 +50,32 @@ var _schemaOnce sync.Once
 // Validate validates a workflow
 func Validate(wf Workflow) error {
 	for name, task := range wf {
+		if m := IncludePattern.FindStringSubmatch(name); m != nil {
+			if len(task) != 1 || !isInclude(task[0]) {
+				return fmt.Errorf("include %q must be set to a reference", name)
+			}
+
+			u, err := url.Parse(task[0].Uses)
+			if err != nil {
+				return fmt.Errorf("include %q %w", name, err)
+			}
+
+			if u.Scheme == "" {
+				return fmt.Errorf("include %q must contain a scheme: %q", name, task[0].Uses)
+			}
+
+			if !uses.SupportsScheme(u.Scheme) {
+				return fmt.Errorf("include %q %q is not one of [%s]", name, u.Scheme, strings.Join(uses.Schemes(), ", "))
+			}
+
+			// the namespace would shadow the scheme in `uses`
+			if uses.SupportsScheme(m[1]) {
+				return fmt.Errorf("include %q conflicts with the %q scheme", name, m[1])
+			}
+
+			continue
+		}
+
 		if ok := TaskNamePattern.MatchString(name); !ok {
 			return fmt.Errorf("task name %q does not satisfy %q", name, TaskNamePattern.String())
 		}
@@ -66,8 +93,14 @@ func Validate(wf Workflow) error {
 				return fmt.Errorf(".%s[%d] has both eval and uses fields set", name, idx)
 			case step.Run != "" && step.Eval != "":
 				return fmt.Errorf(".%s[%d] has both run and eval fields set", name, idx)
-			case step.Uses == "" && step.Run == "" && step.Eval == "":
-				return fmt.Errorf(".%s[%d] must have one of [eval, run, uses] fields set", name, idx)
+			case step.Script != "" && step.Run != "":
+				return fmt.Errorf(".%s[%d] has both script and run fields set", name, idx)
+			case step.Script != "" && step.Uses != "":
+				return fmt.Errorf(".%s[%d] has both script and uses fields set", name, idx)
+			case step.Script != "" && step.Eval != "":
+				return fmt.Errorf(".%s[%d] has both script and eval fields set", name, idx)
+			case step.Uses == "" && step.Run == "" && step.Eval == "" && step.Script == "":
+				return fmt.Errorf(".%s[%d] must have one of [eval, run, script, uses] fields set", name, idx)
 			}
 
 			if step.ID != "" {
@@ -81,31 +114,43 @@ func Validate(wf Workflow) error {
 				ids[step.ID] = idx
 			}
 
-			if step.Uses != "" {
+			if step.Uses != "" && !wf.local(step.Uses) {
 				u, err := url.Parse(step.Uses)
 				if err != nil {
 					return fmt.Errorf(".%s[%d].uses %w", name, idx, err)
 				}
 
 				if u.Scheme == "" {
-					// if step.Uses == name {
-					// 	return fmt.Errorf(".%s[%d].uses cannot reference itself", name, idx)
-					// }
 					_, ok := wf.Find(step.Uses)
 					if !ok {
 						return fmt.Errorf(".%s[%d].uses %q not found", name, idx, step.Uses)
 					}
-				} else {
-					schemes := []string{"file", "http", "https", "pkg"}
+				} else if !uses.SupportsScheme(u.Scheme) {
+					return fmt.Errorf(".%s[%d].uses %q is not one of [%s]", name, idx, u.Scheme, strings.Join(uses.Schemes(), ", "))
+				}
+			}
 
-					if !slices.Contains(schemes, u.Scheme) {
-						return fmt.Errorf(".%s[%d].uses %q is not one of [%s]", name, idx, u.Scheme, strings.Join(schemes, ", "))
-					}
+			if step.Script != "" {
+				u, err := url.Parse(step.Script)
+				if err != nil {
+					return fmt.Errorf(".%s[%d].script %w", name, idx, err)
+				}
+
+				if u.Scheme == "" {
+					return fmt.Errorf(".%s[%d].script must contain a scheme: %q", name, idx, step.Script)
+				}
+
+				if !uses.SupportsScheme(u.Scheme) {
+					return fmt.Errorf(".%s[%d].script %q is not one of [%s]", name, idx, u.Scheme, strings.Join(uses.Schemes(), ", "))
 				}
 			}
 		}
 	}
 
+	if cycle, name, idx := findCycle(wf); cycle != nil {
+		return fmt.Errorf(".%s[%d].uses creates a cycle: %s", name, idx, strings.Join(cycle, " -> "))
+	}
+
 	_schemaOnce.Do(func() {
 		s := WorkFlowSchema()
 		b, err := json.Marshal(s)
@@ -134,6 +179,60 @@ func Validate(wf Workflow) error {
 	return resErr
 }
 
+// isInclude reports whether step only sets `uses`, as an included workflow does.
+func isInclude(step Step) bool {
+	return step.Uses != "" && step.Run == "" && step.Eval == "" && step.Script == "" && step.ID == "" && step.Name == "" && len(step.With) == 0
+}
+
+// findCycle returns the first chain of tasks within wf that calls back into itself,
+// along with the task and step index of the call that closes the cycle.
+func findCycle(wf Workflow) ([]string, string, int) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := make(map[string]int, len(wf))
+	var path []string
+
+	var visit func(name string) ([]string, string, int)
+	visit = func(name string) ([]string, string, int) {
+		state[name] = visiting
+		path = append(path, name)
+
+		for idx, step := range wf[name] {
+			if _, ok := wf.Find(step.Uses); !ok {
+				continue
+			}
+			switch state[step.Uses] {
+			case visiting:
+				start := slices.Index(path, step.Uses)
+				return append(slices.Clone(path[start:]), step.Uses), name, idx
+			case unvisited:
+				if cycle, at, i := visit(step.Uses); cycle != nil {
+					return cycle, at, i
+				}
+			}
+		}
+
+		path = path[:len(path)-1]
+		state[name] = visited
+		return nil, "", 0
+	}
+
+	for _, name := range wf.OrderedTaskNames() {
+		if state[name] != unvisited {
+			continue
+		}
+		if cycle, at, idx := visit(name); cycle != nil {
+			return cycle, at, idx
+		}
+	}
+
+	return nil, "", 0
+}
+
 // ReadAndValidate reads and validates a workflow
 func ReadAndValidate(r io.Reader) (Workflow, error) {
 	wf, err := Read(r)
@@ -354,6 +354,50 @@ echo:
 				}},
 			}, "", `.echo[0].uses "dne" not found`,
 		},
+		{
+			"task calls itself",
+			strings.NewReader(`
+echo:
+  - run: echo
+  - uses: echo
+`),
+			Workflow{
+				"echo": Task{Step{Run: "echo"}, Step{Uses: "echo"}},
+			}, "", `.echo[1].uses creates a cycle: echo -> echo`,
+		},
+		{
+			"tasks call each other",
+			strings.NewReader(`
+a:
+  - uses: b
+b:
+  - uses: c
+c:
+  - uses: a
+d:
+  - uses: a
+`),
+			Workflow{
+				"a": Task{Step{Uses: "b"}},
+				"b": Task{Step{Uses: "c"}},
+				"c": Task{Step{Uses: "a"}},
+				"d": Task{Step{Uses: "a"}},
+			}, "", `.c[0].uses creates a cycle: a -> b -> c -> a`,
+		},
+		{
+			"task called twice is not a cycle",
+			strings.NewReader(`
+a:
+  - uses: b
+  - uses: b
+b:
+  - run: echo
+`),
+			Workflow{
+				"a": Task{Step{Uses: "b"}, Step{Uses: "b"}},
+				"b": Task{Step{Run: "echo"}},
+			}, "", "",
+		},
 		{
 			"unsupported scheme in uses",
 			strings.NewReader(`
@@ -364,7 +408,7 @@ echo:
 				"echo": Task{Step{
 					Uses: "ssh://dne",
 				}},
-			}, "", `.echo[0].uses "ssh" is not one of [file, http, https, pkg]`,
+			}, "", `.echo[0].uses "ssh" is not one of [file, git+file, git+http, git+https, git+ssh, http, https, oci, pkg]`,
 		},
 		{
 			"must have one of run, uses, or eval",
@@ -376,7 +420,7 @@ echo:
 				"echo": Task{Step{
 					ID: "echo-5",
 				}},
-			}, "", `.echo[0] must have one of [eval, run, uses] fields set`,
+			}, "", `.echo[0] must have one of [eval, run, script, uses] fields set`,
 		},
 		{
 			"uses is an invalid url",
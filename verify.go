@@ -0,0 +1,251 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package vai
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/noxsios/vai/uses"
+	"golang.org/x/crypto/blake2b"
+)
+
+// SignatureExt is appended to the file path of a remote workflow to locate its detached minisign signature.
+const SignatureExt = ".minisig"
+
+// VerifyRule requires workflows from matching sources to be signed by one of the given keys.
+type VerifyRule struct {
+	// Match is a list of patterns matched against type/namespace/name for pkg: references and the host otherwise
+	Match []string `json:"match"`
+	// Keys are minisign public keys, the second line of a minisign.pub file
+	Keys []string `json:"keys"`
+}
+
+// VerifyRules are signature requirements for remote workflows.
+//
+// Every rule that matches a source contributes its keys, and a signature by any of them is accepted.
+type VerifyRules []VerifyRule
+
+// Validate checks that every rule has patterns and well formed keys.
+func (r VerifyRules) Validate() error {
+	for i, rule := range r {
+		if len(rule.Match) == 0 || len(rule.Keys) == 0 {
+			return fmt.Errorf("verify[%d] must set both match and keys", i)
+		}
+		for _, pattern := range rule.Match {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("verify[%d] invalid pattern %q: %w", i, pattern, err)
+			}
+		}
+		for _, key := range rule.Keys {
+			if _, err := parseMinisignKey(key); err != nil {
+				return fmt.Errorf("verify[%d] %w", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// keys returns the trusted keys for a fully resolved reference, or nil if it need not be signed.
+//
+// Rules matching the source before any replacements or rewrites apply as well, so redirecting a reference
+// to a mirror does not drop the requirement. References redirected to local files are not remote sources.
+func (r VerifyRules) keys(ref reference) ([]minisignKey, error) {
+	src, err := parseSource(ref.location)
+	if err != nil {
+		return nil, err
+	}
+
+	// local files are not remote sources
+	if src.scheme == "file" {
+		return nil, nil
+	}
+
+	subjects := []string{src.subject()}
+	if ref.requested != "" {
+		requested, err := parseSource(ref.requested)
+		if err != nil {
+			return nil, err
+		}
+		subjects = append(subjects, requested.subject())
+	}
+
+	var keys []minisignKey
+	for _, rule := range r {
+		if !slices.ContainsFunc(subjects, func(subject string) bool {
+			return match(rule.Match, subject)
+		}) {
+			continue
+		}
+		for _, k := range rule.Keys {
+			key, err := parseMinisignKey(k)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+type verifyRulesKey struct{}
+
+// WithVerifyRules returns a copy of ctx in which remote workflows matching r must be signed.
+func WithVerifyRules(ctx context.Context, r VerifyRules) context.Context {
+	return context.WithValue(ctx, verifyRulesKey{}, r)
+}
+
+func verifyRulesFromContext(ctx context.Context) VerifyRules {
+	r, _ := ctx.Value(verifyRulesKey{}).(VerifyRules)
+	return r
+}
+
+// signatureLocation returns the location of the detached signature of the workflow at location.
+//
+// The signature is a sibling of the workflow file, whether that is a path, a pkg: subpath
+// or a file within an OCI artifact or git repository.
+func signatureLocation(location string) (string, error) {
+	uri, err := url.Parse(location)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case uri.Fragment != "":
+		uri.Fragment += SignatureExt
+	case uri.Opaque != "":
+		uri.Opaque += SignatureExt
+	default:
+		uri.Path += SignatureExt
+	}
+
+	return uri.String(), nil
+}
+
+// verify checks the content of the workflow at ref against its detached signature,
+// if a rule in ctx requires one.
+//
+// It returns the location and descriptor of the signature, or an empty location if no signature was required.
+func verify(ctx context.Context, store *uses.Store, ref reference, content []byte) (string, uses.Descriptor, error) {
+	logger := log.FromContext(ctx)
+
+	keys, err := verifyRulesFromContext(ctx).keys(ref)
+	if err != nil {
+		return "", uses.Descriptor{}, err
+	}
+	if len(keys) == 0 {
+		return "", uses.Descriptor{}, nil
+	}
+
+	location, err := signatureLocation(ref.location)
+	if err != nil {
+		return "", uses.Descriptor{}, err
+	}
+
+	sigRef := ref
+	sigRef.location = location
+
+	desc, err := fetch(ctx, store, sigRef)
+	if err != nil {
+		return "", uses.Descriptor{}, fmt.Errorf("%s is not signed: %w", ref.location, err)
+	}
+
+	rc, err := store.Fetch(desc)
+	if err != nil {
+		return "", uses.Descriptor{}, err
+	}
+	defer rc.Close()
+
+	sig, err := io.ReadAll(rc)
+	if err != nil {
+		return "", uses.Descriptor{}, err
+	}
+
+	comment, err := verifyMinisign(keys, content, sig)
+	if err != nil {
+		return "", uses.Descriptor{}, fmt.Errorf("%s: signature verification failed: %w", ref.location, err)
+	}
+
+	logger.Debug("verified", "uses", ref.location, "comment", comment)
+
+	return location, desc, nil
+}
+
+// minisignKey is an Ed25519 public key in minisign format.
+type minisignKey struct {
+	id  uint64
+	key ed25519.PublicKey
+}
+
+func parseMinisignKey(s string) (minisignKey, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(b) != 2+8+ed25519.PublicKeySize || string(b[:2]) != "Ed" {
+		return minisignKey{}, fmt.Errorf("invalid minisign public key %q", s)
+	}
+	return minisignKey{
+		id:  binary.LittleEndian.Uint64(b[2:10]),
+		key: ed25519.PublicKey(b[10:]),
+	}, nil
+}
+
+// verifyMinisign verifies a minisign signature of msg by one of keys, returning its trusted comment.
+//
+// Both prehashed signatures (the default since minisign 0.10), which sign the BLAKE2b-512 hash of msg,
+// and legacy signatures created with `minisign -S -l` are supported.
+func verifyMinisign(keys []minisignKey, msg, sig []byte) (string, error) {
+	lines := strings.Split(strings.ReplaceAll(string(sig), "\r\n", "\n"), "\n")
+	if len(lines) < 4 || !strings.HasPrefix(lines[0], "untrusted comment:") || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		return "", fmt.Errorf("malformed minisign signature")
+	}
+
+	b, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(b) != 2+8+ed25519.SignatureSize {
+		return "", fmt.Errorf("malformed minisign signature")
+	}
+
+	switch string(b[:2]) {
+	case "Ed":
+	case "ED":
+		digest := blake2b.Sum512(msg)
+		msg = digest[:]
+	default:
+		return "", fmt.Errorf("unsupported signature algorithm %q", b[:2])
+	}
+
+	id := binary.LittleEndian.Uint64(b[2:10])
+	signature := b[10:]
+
+	global, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil || len(global) != ed25519.SignatureSize {
+		return "", fmt.Errorf("malformed minisign signature")
+	}
+
+	comment := strings.TrimPrefix(lines[2], "trusted comment: ")
+
+	for _, k := range keys {
+		if k.id != id {
+			continue
+		}
+		if !ed25519.Verify(k.key, msg, signature) {
+			return "", fmt.Errorf("invalid signature by key %016X", id)
+		}
+		// the global signature covers the trusted comment, so it cannot be swapped
+		if !ed25519.Verify(k.key, bytes.Join([][]byte{signature, []byte(comment)}, nil), global) {
+			return "", fmt.Errorf("invalid trusted comment signature by key %016X", id)
+		}
+		return comment, nil
+	}
+
+	return "", fmt.Errorf("signed by untrusted key %016X", id)
+}
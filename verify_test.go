@@ -0,0 +1,218 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Harry Randazzo
+
+package vai
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/noxsios/vai/uses"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/blake2b"
+)
+
+// newMinisignKey generates a key pair, returning the public key in minisign format and a function to sign with it.
+//
+// Signatures are prehashed, like those of `minisign -S`.
+func newMinisignKey(t *testing.T, id uint64) (string, func(msg []byte) []byte) {
+	t.Helper()
+
+	key, sign := newMinisignKeyWithAlgorithm(t, id, "ED")
+	return key, sign
+}
+
+// newMinisignKeyWithAlgorithm is newMinisignKey, signing with the given algorithm: "Ed" for legacy signatures
+// or "ED" for prehashed ones.
+func newMinisignKeyWithAlgorithm(t *testing.T, id uint64, algorithm string) (string, func(msg []byte) []byte) {
+	t.Helper()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	keyID := binary.LittleEndian.AppendUint64(nil, id)
+	key := base64.StdEncoding.EncodeToString(bytes.Join([][]byte{[]byte("Ed"), keyID, pub}, nil))
+
+	sign := func(msg []byte) []byte {
+		if algorithm == "ED" {
+			digest := blake2b.Sum512(msg)
+			msg = digest[:]
+		}
+		sig := ed25519.Sign(priv, msg)
+		comment := "timestamp:0"
+		global := ed25519.Sign(priv, append(sig, comment...))
+		return []byte(fmt.Sprintf("untrusted comment: signature from vai tests\n%s\ntrusted comment: %s\n%s\n",
+			base64.StdEncoding.EncodeToString(bytes.Join([][]byte{[]byte(algorithm), keyID, sig}, nil)),
+			comment,
+			base64.StdEncoding.EncodeToString(global),
+		))
+	}
+
+	return key, sign
+}
+
+func TestVerify(t *testing.T) {
+	key, sign := newMinisignKey(t, 1)
+	otherKey, otherSign := newMinisignKey(t, 2)
+
+	signed := []byte("default:\n  - run: echo 'signed'\n")
+	tampered := []byte("default:\n  - run: echo 'tampered'\n")
+
+	files := map[string][]byte{
+		"/signed.yaml":           signed,
+		"/signed.yaml.minisig":   sign(signed),
+		"/tampered.yaml":         tampered,
+		"/tampered.yaml.minisig": sign(signed),
+		"/other.yaml":            signed,
+		"/other.yaml.minisig":    otherSign(signed),
+		"/unsigned.yaml":         signed,
+	}
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		b, ok := files[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(b)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	store, err := uses.NewStore(afero.NewMemMapFs())
+	require.NoError(t, err)
+
+	ctx := WithVerifyRules(context.Background(), VerifyRules{
+		{Match: []string{"127.0.0.1"}, Keys: []string{key}},
+	})
+
+	require.NoError(t, ExecuteUses(ctx, store, server.URL+"/signed.yaml", With{}, "file:vai.yaml", false))
+
+	err = ExecuteUses(ctx, store, server.URL+"/tampered.yaml", With{}, "file:vai.yaml", false)
+	require.EqualError(t, err, server.URL+"/tampered.yaml: signature verification failed: invalid signature by key 0000000000000001")
+
+	err = ExecuteUses(ctx, store, server.URL+"/other.yaml", With{}, "file:vai.yaml", false)
+	require.EqualError(t, err, server.URL+"/other.yaml: signature verification failed: signed by untrusted key 0000000000000002")
+
+	err = ExecuteUses(ctx, store, server.URL+"/unsigned.yaml", With{}, "file:vai.yaml", false)
+	require.EqualError(t, err, fmt.Sprintf("%[1]s/unsigned.yaml is not signed: failed to fetch %[1]s/unsigned.yaml.minisig: 404 Not Found", server.URL))
+
+	// any matching rule's keys are trusted
+	ctx = WithVerifyRules(ctx, VerifyRules{
+		{Match: []string{"127.0.0.1"}, Keys: []string{key}},
+		{Match: []string{"*"}, Keys: []string{otherKey}},
+	})
+	require.NoError(t, ExecuteUses(ctx, store, server.URL+"/other.yaml", With{}, "file:vai.yaml", false))
+
+	// sources that do not match any rule need not be signed
+	ctx = WithVerifyRules(context.Background(), VerifyRules{
+		{Match: []string{"github/acme/*"}, Keys: []string{key}},
+	})
+	require.NoError(t, ExecuteUses(ctx, store, server.URL+"/unsigned.yaml", With{}, "file:vai.yaml", false))
+
+	t.Run("rewrite", func(t *testing.T) {
+		ctx := WithVerifyRules(context.Background(), VerifyRules{
+			{Match: []string{"mirror.example"}, Keys: []string{key}},
+		})
+		ctx = WithRewrites(ctx, Rewrites{
+			{From: "https://mirror.example/", To: server.URL + "/"},
+		})
+
+		// the rule for the requested source still applies once it is rewritten
+		require.NoError(t, ExecuteUses(ctx, store, "https://mirror.example/signed.yaml", With{}, "file:vai.yaml", false))
+
+		err := ExecuteUses(ctx, store, "https://mirror.example/unsigned.yaml", With{}, "file:vai.yaml", false)
+		require.EqualError(t, err, fmt.Sprintf("%[1]s/unsigned.yaml is not signed: failed to fetch %[1]s/unsigned.yaml.minisig: 404 Not Found", server.URL))
+	})
+
+	t.Run("bundle", func(t *testing.T) {
+		ctx := WithVerifyRules(context.Background(), VerifyRules{
+			{Match: []string{"127.0.0.1"}, Keys: []string{key}},
+		})
+
+		var buf bytes.Buffer
+		require.NoError(t, CreateBundle(ctx, store, server.URL+"/signed.yaml", nil, &buf))
+
+		offline, err := uses.NewStore(afero.NewMemMapFs())
+		require.NoError(t, err)
+
+		wf, manifest, err := OpenBundle(offline, &buf)
+		require.NoError(t, err)
+		require.Contains(t, manifest.Refs, server.URL+"/signed.yaml.minisig")
+
+		// signatures are verified again from the bundle, without network access
+		ctx = WithRefIndex(ctx, manifest.Refs)
+		require.NoError(t, ExecuteUses(ctx, offline, manifest.Origin, With{}, "file:vai.yaml", false))
+		require.NoError(t, Run(ctx, offline, wf, DefaultTaskName, With{}, manifest.Origin, false))
+	})
+}
+
+func TestVerifyMinisign(t *testing.T) {
+	for _, algorithm := range []string{"Ed", "ED"} {
+		t.Run(algorithm, func(t *testing.T) {
+			key, sign := newMinisignKeyWithAlgorithm(t, 0xABCDEF, algorithm)
+			k, err := parseMinisignKey(key)
+			require.NoError(t, err)
+
+			msg := []byte("hello")
+			sig := sign(msg)
+
+			comment, err := verifyMinisign([]minisignKey{k}, msg, sig)
+			require.NoError(t, err)
+			require.Equal(t, "timestamp:0", comment)
+
+			_, err = verifyMinisign([]minisignKey{k}, []byte("goodbye"), sig)
+			require.EqualError(t, err, "invalid signature by key 0000000000ABCDEF")
+
+			// swapping the trusted comment invalidates the global signature
+			swapped := bytes.Replace(sig, []byte("timestamp:0"), []byte("timestamp:1"), 1)
+			_, err = verifyMinisign([]minisignKey{k}, msg, swapped)
+			require.EqualError(t, err, "invalid trusted comment signature by key 0000000000ABCDEF")
+		})
+	}
+
+	key, sign := newMinisignKey(t, 0xABCDEF)
+	k, err := parseMinisignKey(key)
+	require.NoError(t, err)
+
+	// a prehashed signature does not verify as a legacy one
+	lines := strings.Split(string(sign([]byte("hello"))), "\n")
+	b, err := base64.StdEncoding.DecodeString(lines[1])
+	require.NoError(t, err)
+	lines[1] = base64.StdEncoding.EncodeToString(append([]byte("Ed"), b[2:]...))
+	legacy := []byte(strings.Join(lines, "\n"))
+	_, err = verifyMinisign([]minisignKey{k}, []byte("hello"), legacy)
+	require.EqualError(t, err, "invalid signature by key 0000000000ABCDEF")
+
+	_, err = verifyMinisign([]minisignKey{k}, []byte("hello"), []byte("not a signature"))
+	require.EqualError(t, err, "malformed minisign signature")
+
+	_, err = parseMinisignKey("RWQ")
+	require.EqualError(t, err, `invalid minisign public key "RWQ"`)
+}
+
+func TestSignatureLocation(t *testing.T) {
+	testCases := map[string]string{
+		"https://example.com/vai.yaml?task=echo":          "https://example.com/vai.yaml.minisig?task=echo",
+		"pkg:github/acme/tasks@v1.0.0?task=echo#vai.yaml": "pkg:github/acme/tasks@v1.0.0?task=echo#vai.yaml.minisig",
+		"oci://ghcr.io/acme/tasks:v1#dir/vai.yaml":        "oci://ghcr.io/acme/tasks:v1#dir/vai.yaml.minisig",
+		"git+https://example.com/repo.git@v1#vai.yaml":    "git+https://example.com/repo.git@v1#vai.yaml.minisig",
+		"file:tasks/vai.yaml":                             "file:tasks/vai.yaml.minisig",
+	}
+
+	for location, expected := range testCases {
+		got, err := signatureLocation(location)
+		require.NoError(t, err)
+		require.Equal(t, expected, got)
+	}
+}